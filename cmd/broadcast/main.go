@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/harmony-one/libunison/internal/ida/coopcast"
 	"github.com/harmony-one/libunison/internal/ida/manycast"
-	"io/ioutil"
 	"log"
+	"math"
 	"math/rand"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-func initCoopCastNode(confignbr string, configallpeer string, t0 float64, t1 float64, t2 float64, base float64, hop int) *coopcast.Node {
+func initCoopCastNode(confignbr string, configallpeer string, keyfile string, opts coopcast.Options) *coopcast.Node {
 	rand.Seed(time.Now().UTC().UnixNano())
 	config1 := NewConfig()
 	err := config1.ReadConfigFile(confignbr)
@@ -28,11 +32,13 @@ func initCoopCastNode(confignbr string, configallpeer string, t0 float64, t1 flo
 	}
 
 	_, _, allPeers := config2.GetPeerInfo()
-	cache := make(map[coopcast.HashKey]*coopcast.RaptorQImpl)
-	senderCache := make(map[coopcast.HashKey]bool)
-	peerDecodedCounter := make(map[coopcast.HashKey]map[int]int)
-	node := coopcast.Node{SelfPeer: selfPeer, PeerList: peerList, AllPeers: allPeers, Cache: cache, PeerDecodedCounter: peerDecodedCounter, SenderCache: senderCache, InitialDelayTime: t0, MaxDelayTime: t1, ExpBase: base, RelayTime: t2, Hop: hop}
-	return &node
+	privKey, err := ReadKeyFile(keyfile)
+	if err != nil {
+		log.Printf("unable to read key file %v: %v", keyfile, err)
+		return nil
+	}
+	opts.SelfPeer, opts.PeerList, opts.AllPeers, opts.PrivKey = selfPeer, peerList, allPeers, privKey
+	return coopcast.NewNode(opts)
 }
 
 func initManyCastNode(confignbr string, configallpeer string) *manycast.Node {
@@ -63,12 +69,36 @@ func main() {
 	msgFile := flag.String("msg_file", "test.txt", "message file to broadcast")
 	configFile := flag.String("nbr_config", "configs/config_0.txt", "config file contains neighbor peers")
 	allPeerFile := flag.String("all_config", "configs/config_allpeers.txt", "config file contains all peer nodes info")
+	keyFile := flag.String("key_file", "configs/key_0.txt", "file contains the private key of the node")
 	mode := flag.String("mode", "coopcast", "choose broadcast testing mode, [coopcast|manycast]")
 	t0 := flag.Float64("t0", 5, "initial delay time for symbol broadcasting")
 	t1 := flag.Float64("t1", 50, "uppper bound delay time for symbol broadcasting")
 	t2 := flag.Float64("t2", 7, "delay time for symbol relay")
 	hop := flag.Int("hop", 1, "number of hops")
 	base := flag.Float64("base", 1.05, "base of exponential increase of symbol broadcasting delay")
+	hashName := flag.String("hash", "sha256", "digest of broadcast messages, [sha256|blake2b-256]")
+	defaults := coopcast.DefaultEncodingParams()
+	symbolSize := flag.Uint("symbol_size", uint(defaults.SymbolSize), "RaptorQ symbol size in bytes, payload of a packet")
+	chunkSize := flag.Uint("chunk_size", uint(defaults.ChunkSize), "size in bytes of the independently encoded chunks of a message")
+	alignment := flag.Uint("alignment", uint(defaults.Alignment), "RaptorQ symbol alignment, must divide symbol_size")
+	subBlocks := flag.Uint("sub_blocks", uint(defaults.SubBlocks), "number of RaptorQ sub-blocks of a chunk")
+	window := flag.Int("window", 8, "number of chunks broadcast concurrently")
+	timeout := flag.Duration("timeout", 100*time.Second, "time after which the sender stops broadcasting")
+	workers := flag.Int("workers", 0, "number of goroutines decoding the received symbols, one per CPU if 0")
+	queueSize := flag.Int("queue_size", 0, "capacity of the queues between the reader, the decoding workers and the relays, 1024 if 0")
+	relayWorkers := flag.Int("relay_workers", 0, "number of goroutines relaying the received symbols, 256 if 0")
+	fanoutName := flag.String("fanout", "all", "peers the received symbols are relayed to, [all|random|unfinished|tree]")
+	fanoutPeers := flag.Int("fanout_peers", 0, "neighbors the random fanout relays to, 4 if 0")
+	relayDropName := flag.String("relay_drop", "oldest", "symbol dropped when the relay queue is full, [oldest|delivered|newest]")
+	pacingName := flag.String("pacing", "exponential", "pacer of the broadcast symbols, [exponential|constant|linear|token-bucket|adaptive]")
+	relayPacingName := flag.String("relay_pacing", "constant", "pacer of the relayed symbols, [exponential|constant|linear|token-bucket|adaptive]")
+	rate := flag.Float64("rate", 0, "symbols per second of the token-bucket pacers, one per t0 or t2 if 0")
+	burst := flag.Int("burst", 0, "symbols sent at once by the token-bucket pacers, a batch if 0")
+	maxRate := flag.Float64("max_rate", 0, "symbols per second of a broadcast across its chunks, unlimited if 0")
+	uploadRate := flag.Int64("upload_rate", 0, "bytes per second sent by the node, broadcast and relayed symbols together, unlimited if 0")
+	uploadBurst := flag.Int64("upload_burst", 0, "bytes sent at once after an idle period, 256 KiB if 0")
+	messageUpload := flag.Int64("message_upload", 0, "bytes sent by the node per message, unlimited if 0")
+	stream := flag.Bool("stream", false, "write received chunks to disk as soon as they are decoded instead of reassembling messages in memory")
 	flag.Parse()
 
 	if *generateConfigFiles {
@@ -78,31 +108,105 @@ func main() {
 
 	switch *mode {
 	case "coopcast":
-		node := initCoopCastNode(*configFile, *allPeerFile, *t0, *t1, *t2, *base, *hop)
+		hashType, err := coopcast.ParseHashType(*hashName)
+		if err != nil {
+			log.Printf("%v", err)
+			return
+		}
+		pacing, err := coopcast.ParsePacingStrategy(*pacingName)
+		if err != nil {
+			log.Printf("%v", err)
+			return
+		}
+		relayPacing, err := coopcast.ParsePacingStrategy(*relayPacingName)
+		if err != nil {
+			log.Printf("%v", err)
+			return
+		}
+		relayDrop, err := coopcast.ParseRelayDropPolicy(*relayDropName)
+		if err != nil {
+			log.Printf("%v", err)
+			return
+		}
+		fanout, err := coopcast.ParseFanoutPolicy(*fanoutName)
+		if err != nil {
+			log.Printf("%v", err)
+			return
+		}
+		if *symbolSize > math.MaxUint16 || *alignment > math.MaxUint8 || *subBlocks > math.MaxUint16 || *chunkSize > math.MaxUint32 {
+			log.Printf("encoding parameters out of range")
+			return
+		}
+		encoding := coopcast.EncodingParams{SymbolSize: uint16(*symbolSize), Alignment: uint8(*alignment), SubBlocks: uint16(*subBlocks), ChunkSize: uint32(*chunkSize)}
+		if err := encoding.Validate(); err != nil {
+			log.Printf("invalid encoding parameters: %v", err)
+			return
+		}
+		opts := coopcast.Options{
+			InitialDelayTime: *t0,
+			MaxDelayTime:     *t1,
+			ExpBase:          *base,
+			RelayTime:        *t2,
+			Hop:              *hop,
+			HashType:         hashType,
+			Encoding:         encoding,
+			Pipeline:         coopcast.PipelineOptions{Workers: *workers, QueueSize: *queueSize, RelayWorkers: *relayWorkers, RelayPolicy: relayDrop},
+			Pacing:           coopcast.PacingOptions{Strategy: pacing, Rate: *rate, Burst: *burst, MaxRate: *maxRate},
+			RelayPacing:      coopcast.PacingOptions{Strategy: relayPacing, Rate: *rate, Burst: *burst},
+			Upload:           coopcast.UploadLimits{BytesPerSecond: *uploadRate, Burst: *uploadBurst, MessageBytes: *messageUpload},
+			Fanout:           coopcast.FanoutOptions{Policy: fanout, Fanout: *fanoutPeers},
+		}
+		node := initCoopCastNode(*configFile, *allPeerFile, *keyFile, opts)
 		if node == nil {
 			log.Printf("unable to create node")
 			return
 		}
+		if *stream {
+			node.OnStream(coopcast.FileStream("received"))
+		} else {
+			node.OnMessage(coopcast.FileSink("received"))
+		}
 		uaddr := net.JoinHostPort("", node.SelfPeer.UDPPort)
-		pc, err := net.ListenPacket("udp", uaddr)
+		pc, err := node.Transport.ListenPacket(uaddr)
 		if err != nil {
 			log.Printf("cannot listen on udp port")
 			return
 		}
 		log.Printf("server start listening on udp port %s", node.SelfPeer.UDPPort)
 
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+			log.Printf("shutting down node")
+			cancel()
+		}()
+
 		if *broadCast {
-			go node.ListeningOnBroadCast(pc)
-			filecontent, err := ioutil.ReadFile(*msgFile)
+			if err := node.Start(ctx, pc); err != nil {
+				log.Printf("cannot start node: %v", err)
+				return
+			}
+			defer node.Close()
+			file, size, err := openMessageFile(*msgFile)
 			if err != nil {
 				log.Printf("cannot open file %s", *msgFile)
 				return
 			}
-			log.Printf("file size is %v", len(filecontent))
-			cancels, raptorq := node.BroadCast(filecontent, pc)
-			node.StopBroadCast(cancels, raptorq)
-		} else {
-			node.ListeningOnBroadCast(pc)
+			defer file.Close()
+			log.Printf("file size is %v", size)
+			handle, err := node.BroadCastReader(file, size, pc, coopcast.BroadCastOptions{Window: *window, Timeout: *timeout})
+			if err != nil {
+				log.Printf("cannot broadcast file %s: %v", *msgFile, err)
+				return
+			}
+			if err := handle.Wait(); err != nil {
+				log.Printf("broadcast of %s stopped: %v", *msgFile, err)
+			}
+		} else if err := node.ListeningOnBroadCast(ctx, pc); err != nil {
+			log.Printf("node stopped listening: %v", err)
 		}
 	case "manycast":
 		node := initManyCastNode(*configFile, *allPeerFile)
@@ -111,13 +215,14 @@ func main() {
 			return
 		}
 		if *broadCast {
-			filecontent, err := ioutil.ReadFile(*msgFile)
+			file, size, err := openMessageFile(*msgFile)
 			if err != nil {
 				log.Printf("cannot open file %s", *msgFile)
 				return
 			}
-			log.Printf("file size is %v", len(filecontent))
-			node.BroadCast(filecontent)
+			defer file.Close()
+			log.Printf("file size is %v", size)
+			node.BroadCastReader(file, size)
 		} else {
 			node.ListeningOnUniCast()
 		}
@@ -3,4 +3,4 @@
 # ./send_file 4 test.txt [coopcast|manycast]
 
 mkdir -p received
-./ida -nbr_config configs/config_$1.txt -all_config configs/config_allpeers.txt -broadcast -msg_file $2 -mode $3
+./ida -nbr_config configs/config_$1.txt -all_config configs/config_allpeers.txt -key_file configs/key_$1.txt -broadcast -msg_file $2 -mode $3
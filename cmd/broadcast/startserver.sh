@@ -12,6 +12,6 @@ do
         break
         ;;
     esac
-   ./ida -nbr_config configs/config_$i.txt  -all_config configs/config_allpeers.txt > logs/server_$i.out -mode $2 2>&1 &
+   ./ida -nbr_config configs/config_$i.txt  -all_config configs/config_allpeers.txt -key_file configs/key_$i.txt > logs/server_$i.out -mode $2 2>&1 &
    i=$((${i} + 1))
 done
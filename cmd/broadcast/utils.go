@@ -2,11 +2,13 @@ package main
 
 import (
 	"bufio"
+	"crypto/ed25519"
 	"encoding/hex"
+	"fmt"
 	ida "github.com/harmony-one/libunison/internal/ida/coopcast"
 	"io"
+	"io/ioutil"
 	"log"
-	"math/rand"
 	"os"
 	"strconv"
 	"strings"
@@ -22,8 +24,6 @@ const (
 	All      Role = 2
 )
 
-const pubKeySize int = 20
-
 // PeerConfig is a single config of a node.
 type PeerConfig struct {
 	Sid     string // SimpleID, might be replaced later for more generic ID like byte array
@@ -89,6 +89,43 @@ func (config *Config) ReadConfigFile(filename string) error {
 	return nil
 }
 
+// ReadKeyFile reads the hex encoded ed25519 private key of a node
+func ReadKeyFile(filename string) (ed25519.PrivateKey, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("private key has %v bytes, need %v bytes", len(buf), ed25519.PrivateKeySize)
+	}
+	return ed25519.PrivateKey(buf), nil
+}
+
+// openMessageFile opens the file to broadcast and returns its size
+func openMessageFile(filename string) (*os.File, int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+func writeKeyFile(filename string, priv ed25519.PrivateKey) {
+	err := ioutil.WriteFile(filename, []byte(hex.EncodeToString(priv)+"\n"), 0600)
+	if err != nil {
+		log.Printf("cannot create file %v", filename)
+	}
+}
+
 // GenerateConfigFromGraph generate config files from graph config file using adjacent map definition of a graph
 func GenerateConfigFromGraph(graphfile string) {
 	file, err := os.Open(graphfile)
@@ -132,16 +169,16 @@ func initConfig(n int) (map[int][]byte, []int, []int) {
 		ts := strconv.Itoa(tcpport)
 		us := strconv.Itoa(udpport)
 		line := sid + " 127.0.0.1 " + ts + " " + us + " "
-		buf := make([]byte, pubKeySize)
-		_, err := rand.Read(buf)
+		pub, priv, err := ed25519.GenerateKey(nil)
 		if err != nil {
-			log.Printf("unable to create random number")
+			log.Printf("unable to create key pair")
 		}
-		pubkey := hex.EncodeToString(buf)
+		writeKeyFile("configs/key_"+sid+".txt", priv)
+		pubkey := hex.EncodeToString(pub)
 		line = line + pubkey + " all\n"
 		tcps[i] = tcpport
 		udps[i] = udpport
-		pubkeys[i] = buf
+		pubkeys[i] = pub
 		udpport++
 		tcpport++
 		io.WriteString(f, line)
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/harmony-one/libunison/internal/ida/coopcast"
+	"github.com/harmony-one/libunison/internal/ida/simulator"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var distributions = map[string]simulator.Distribution{
+	"constant":    simulator.Constant,
+	"uniform":     simulator.Uniform,
+	"normal":      simulator.Normal,
+	"exponential": simulator.Exponential,
+}
+
+// parseCrashes parses a comma separated list of node@time, such as "3@2s,4@500ms"
+func parseCrashes(s string) (map[int]time.Duration, error) {
+	crashes := make(map[int]time.Duration)
+	if s == "" {
+		return crashes, nil
+	}
+	for _, crash := range strings.Split(s, ",") {
+		fields := strings.SplitN(crash, "@", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid crash %q, expected node@time", crash)
+		}
+		node, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid crash %q: %v", crash, err)
+		}
+		at, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid crash %q: %v", crash, err)
+		}
+		crashes[node] = at
+	}
+	return crashes, nil
+}
+
+// parseAdversaries parses a comma separated list of behavior=node, such as "drop=3,flood=4"
+func parseAdversaries(s string) (map[int]simulator.Adversary, error) {
+	adversaries := make(map[int]simulator.Adversary)
+	if s == "" {
+		return adversaries, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid adversary %q, expected behavior=node", entry)
+		}
+		adversary, err := simulator.ParseAdversary(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		node, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid adversary %q: %v", entry, err)
+		}
+		adversaries[node] = adversary
+	}
+	return adversaries, nil
+}
+
+func main() {
+	graphFile := flag.String("graph", "cmd/broadcast/graph1.txt", "file containing network structure, see cmd/broadcast")
+	sender := flag.Int("sender", 0, "node broadcasting the message")
+	size := flag.Int("size", 100000, "size in bytes of the broadcast message")
+	latency := flag.Duration("latency", 20*time.Millisecond, "one way latency of every link")
+	jitter := flag.Duration("jitter", 5*time.Millisecond, "spread of the latency of every link")
+	distribution := flag.String("distribution", "uniform", "distribution of the latency, [constant|uniform|normal|exponential]")
+	loss := flag.Float64("loss", 0, "probability that a datagram is lost")
+	bandwidth := flag.Int64("bandwidth", 0, "bytes per second of every link, unlimited if 0")
+	crash := flag.String("crash", "", "nodes crashing during the broadcast, such as 3@2s,4@500ms")
+	adversary := flag.String("adversary", "", "byzantine nodes, such as drop=3,flood=4, behaviors are [drop|corrupt|forge|replay|flood]")
+	t0 := flag.Float64("t0", 5, "initial delay time for symbol broadcasting")
+	t1 := flag.Float64("t1", 50, "uppper bound delay time for symbol broadcasting")
+	t2 := flag.Float64("t2", 7, "delay time for symbol relay")
+	hop := flag.Int("hop", 1, "number of hops")
+	base := flag.Float64("base", 1.05, "base of exponential increase of symbol broadcasting delay")
+	pacingName := flag.String("pacing", "exponential", "pacer of the broadcast symbols, [exponential|constant|linear|token-bucket|adaptive]")
+	relayPacingName := flag.String("relay_pacing", "constant", "pacer of the relayed symbols, [exponential|constant|linear|token-bucket|adaptive]")
+	rate := flag.Float64("rate", 0, "symbols per second of the token-bucket pacers, one per t0 or t2 if 0")
+	burst := flag.Int("burst", 0, "symbols sent at once by the token-bucket pacers, a batch if 0")
+	fanoutName := flag.String("fanout", "all", "peers the received symbols are relayed to, [all|random|unfinished|tree]")
+	fanoutPeers := flag.Int("fanout_peers", 0, "neighbors the random fanout relays to, 4 if 0")
+	relayDropName := flag.String("relay_drop", "oldest", "symbol dropped when a relay queue is full, [oldest|delivered|newest]")
+	maxRate := flag.Float64("max_rate", 0, "symbols per second of the broadcast across its chunks, unlimited if 0")
+	uploadRate := flag.Int64("upload_rate", 0, "bytes per second sent by every node, broadcast and relayed symbols together, unlimited if 0")
+	uploadBurst := flag.Int64("upload_burst", 0, "bytes sent at once after an idle period, 256 KiB if 0")
+	messageUpload := flag.Int64("message_upload", 0, "bytes sent by every node per message, unlimited if 0")
+	seed := flag.Int64("seed", 1, "seed of the message, the keys, the losses and the latencies")
+	timeout := flag.Duration("timeout", 60*time.Second, "time after which the simulation stops")
+	verbose := flag.Bool("verbose", false, "print the logs of the nodes")
+	flag.Parse()
+
+	graph, err := simulator.ReadGraphFile(*graphFile)
+	if err != nil {
+		log.Fatalf("cannot read graph %v: %v", *graphFile, err)
+	}
+	dist, ok := distributions[*distribution]
+	if !ok {
+		log.Fatalf("unknown distribution %q", *distribution)
+	}
+	crashes, err := parseCrashes(*crash)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	adversaries, err := parseAdversaries(*adversary)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	pacing, err := coopcast.ParsePacingStrategy(*pacingName)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	relayPacing, err := coopcast.ParsePacingStrategy(*relayPacingName)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	relayDrop, err := coopcast.ParseRelayDropPolicy(*relayDropName)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	fanout, err := coopcast.ParseFanoutPolicy(*fanoutName)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	cfg := simulator.Config{
+		Graph:       graph,
+		Sender:      *sender,
+		MessageSize: *size,
+		Link:        simulator.LinkModel{Latency: *latency, Jitter: *jitter, Distribution: dist, LossRate: *loss, Bandwidth: *bandwidth},
+		Crashes:     crashes,
+		Adversaries: adversaries,
+		Seed:        *seed,
+		Timeout:     *timeout,
+		Node: coopcast.Options{
+			InitialDelayTime: *t0,
+			MaxDelayTime:     *t1,
+			ExpBase:          *base,
+			RelayTime:        *t2,
+			Hop:              *hop,
+			Pacing:           coopcast.PacingOptions{Strategy: pacing, Rate: *rate, Burst: *burst, MaxRate: *maxRate},
+			RelayPacing:      coopcast.PacingOptions{Strategy: relayPacing, Rate: *rate, Burst: *burst},
+			Pipeline:         coopcast.PipelineOptions{RelayPolicy: relayDrop},
+			Fanout:           coopcast.FanoutOptions{Policy: fanout, Fanout: *fanoutPeers},
+			Upload:           coopcast.UploadLimits{BytesPerSecond: *uploadRate, Burst: *uploadBurst, MessageBytes: *messageUpload},
+		},
+	}
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	report, err := simulator.Run(context.Background(), cfg)
+	if err != nil {
+		fmt.Printf("simulation failed: %v\n", err)
+		return
+	}
+	fmt.Print(report)
+	if err := report.Check(); err != nil {
+		fmt.Printf("check failed: %v\n", err)
+		os.Exit(1)
+	}
+}
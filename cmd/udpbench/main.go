@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/udpbatch"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// result of a benchmark run
+type result struct {
+	mode     string
+	sent     int
+	received int64
+	elapsed  time.Duration
+}
+
+func (r result) String() string {
+	seconds := r.elapsed.Seconds()
+	loss := 100 * (1 - float64(r.received)/float64(r.sent))
+	return fmt.Sprintf("%-7s sent %9.0f pkt/s  received %9.0f pkt/s  loss %5.2f%%", r.mode, float64(r.sent)/seconds, float64(r.received)/seconds, loss)
+}
+
+// listen opens a receiving socket for every peer on the loopback interface
+func listen(peers int) ([]net.PacketConn, error) {
+	var pcs []net.PacketConn
+	for i := 0; i < peers; i++ {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			for _, pc := range pcs {
+				pc.Close()
+			}
+			return nil, err
+		}
+		pcs = append(pcs, pc)
+	}
+	return pcs, nil
+}
+
+// receive counts the datagrams read from pc until no datagram arrives for a while after done is closed
+func receive(pc net.PacketConn, batched bool, batchSize int, size int, done chan struct{}, count *int64) {
+	conn := udpbatch.NewConn(pc)
+	msgs := make([]udpbatch.Message, 1)
+	if batched {
+		msgs = make([]udpbatch.Message, batchSize)
+	}
+	for i := range msgs {
+		msgs[i].Buf = make([]byte, size)
+	}
+	for {
+		select {
+		case <-done:
+			pc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		default:
+			pc.SetReadDeadline(time.Now().Add(time.Second))
+		}
+		n, err := conn.ReadBatch(msgs)
+		if err != nil {
+			select {
+			case <-done:
+				return
+			default:
+				continue
+			}
+		}
+		atomic.AddInt64(count, int64(n))
+	}
+}
+
+// run sends packets datagrams round robin to the receivers, one by one like the former symbol path,
+// resolving the address of every datagram, or in batches to pre-resolved addresses
+func run(batched bool, packets int, size int, batchSize int, peers int) (result, error) {
+	mode := "single"
+	if batched {
+		mode = "batch"
+	}
+	pcs, err := listen(peers)
+	if err != nil {
+		return result{}, err
+	}
+	sender, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		for _, pc := range pcs {
+			pc.Close()
+		}
+		return result{}, err
+	}
+	defer sender.Close()
+
+	var received int64
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	addrs := make([]net.Addr, peers)
+	for i, pc := range pcs {
+		addrs[i] = pc.LocalAddr()
+		wg.Add(1)
+		go func(pc net.PacketConn) {
+			defer wg.Done()
+			defer pc.Close()
+			receive(pc, batched, batchSize, size, done, &received)
+		}(pc)
+	}
+
+	payload := make([]byte, size)
+	conn := udpbatch.NewConn(sender)
+	if batched && !conn.Batched() {
+		log.Printf("batch system calls are not supported on this platform, the batch mode only pre-resolves addresses")
+	}
+	start := time.Now()
+	if batched {
+		batch := make([]udpbatch.Message, 0, batchSize)
+		for i := 0; i < packets; i += len(batch) {
+			batch = batch[:0]
+			for j := i; j < packets && len(batch) < batchSize; j++ {
+				batch = append(batch, udpbatch.Message{Buf: payload, Addr: addrs[j%peers]})
+			}
+			if _, err := conn.WriteBatch(batch); err != nil {
+				log.Printf("write batch failed: %v", err)
+			}
+		}
+	} else {
+		for i := 0; i < packets; i++ {
+			addr, err := net.ResolveUDPAddr("udp", addrs[i%peers].String())
+			if err != nil {
+				log.Printf("cannot resolve udp address %v", addrs[i%peers])
+				continue
+			}
+			if _, err := sender.WriteTo(payload, addr); err != nil {
+				log.Printf("write failed: %v", err)
+			}
+		}
+	}
+	elapsed := time.Since(start)
+	close(done)
+	wg.Wait()
+	return result{mode: mode, sent: packets, received: atomic.LoadInt64(&received), elapsed: elapsed}, nil
+}
+
+func main() {
+	packets := flag.Int("packets", 200000, "number of datagrams sent by every run")
+	size := flag.Int("size", 1300, "datagram size in bytes, a 1200 bytes symbol takes about 1300 bytes on the wire")
+	batchSize := flag.Int("batch", 32, "datagrams per system call of the batch mode")
+	peers := flag.Int("peers", 4, "number of receiving sockets, datagrams are sent round robin like symbols to neighbors")
+	mode := flag.String("mode", "all", "path to benchmark, [single|batch|all]")
+	flag.Parse()
+
+	if *packets <= 0 || *size <= 0 || *batchSize <= 0 || *peers <= 0 {
+		log.Printf("packets, size, batch and peers must be positive")
+		return
+	}
+	var modes []bool
+	switch *mode {
+	case "single":
+		modes = []bool{false}
+	case "batch":
+		modes = []bool{true}
+	case "all":
+		modes = []bool{false, true}
+	default:
+		log.Printf("unknown mode %v", *mode)
+		return
+	}
+	for _, batched := range modes {
+		r, err := run(batched, *packets, *size, *batchSize, *peers)
+		if err != nil {
+			log.Printf("benchmark failed: %v", err)
+			return
+		}
+		fmt.Println(r)
+	}
+}
@@ -0,0 +1,149 @@
+package coopcast
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+)
+
+var errNoPrivateKey = errors.New("node has no private key to sign symbols")
+
+// AuthStats counts symbol packets checked against the signature of their sender,
+// and acknowledgements checked against the signature of the acknowledging peer
+type AuthStats struct {
+	Verified      uint64 // packets carrying a valid sender signature
+	UnknownSender uint64 // packets whose sender is not in AllPeers while AllowUnknownSenders is off
+	BadSignature  uint64 // packets whose signature does not match the content
+	Malformed     uint64 // packets rejected by the wire decoder
+
+	Acks          uint64 // acknowledgements counted towards stopping a broadcast
+	BadAcks       uint64 // acknowledgements from a peer outside AllPeers or whose signature does not match
+	DuplicateAcks uint64 // acknowledgements of a chunk already acknowledged by the same peer
+}
+
+// ParsePubKey decodes the hex encoded ed25519 public key of a peer
+func ParsePubKey(s string) (ed25519.PublicKey, error) {
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key has %v bytes, need %v bytes", len(buf), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(buf), nil
+}
+
+// AuthStats returns a snapshot of the symbol authentication counters
+func (node *Node) AuthStats() AuthStats {
+	return AuthStats{
+		Verified:      atomic.LoadUint64(&node.authStats.Verified),
+		UnknownSender: atomic.LoadUint64(&node.authStats.UnknownSender),
+		BadSignature:  atomic.LoadUint64(&node.authStats.BadSignature),
+		Malformed:     atomic.LoadUint64(&node.authStats.Malformed),
+		Acks:          atomic.LoadUint64(&node.authStats.Acks),
+		BadAcks:       atomic.LoadUint64(&node.authStats.BadAcks),
+		DuplicateAcks: atomic.LoadUint64(&node.authStats.DuplicateAcks),
+	}
+}
+
+// String returns the hex encoded public key
+func (id PeerID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func peerIDOf(key []byte) PeerID {
+	var id PeerID
+	copy(id[:], key)
+	return id
+}
+
+func (node *Node) loadPeerKeys() {
+	node.peers = make(map[PeerID]Peer)
+	node.allIDs = make([]PeerID, len(node.AllPeers))
+	for i, peer := range node.AllPeers {
+		key, err := ParsePubKey(peer.PubKey)
+		if err != nil {
+			log.Printf("invalid public key for peer %v: %v", peer.Sid, err)
+			continue
+		}
+		node.peers[peerIDOf(key)] = peer
+		node.allIDs[i] = peerIDOf(key)
+	}
+	node.neighbors = make(map[PeerID]int)
+	for i, peer := range node.PeerList {
+		if key, err := ParsePubKey(peer.PubKey); err == nil {
+			node.neighbors[peerIDOf(key)] = i
+		}
+	}
+	if len(node.privKey) == ed25519.PrivateKeySize {
+		node.selfID = peerIDOf(node.privKey.Public().(ed25519.PublicKey))
+	} else if key, err := ParsePubKey(node.SelfPeer.PubKey); err == nil {
+		node.selfID = peerIDOf(key)
+	}
+}
+
+// sid returns the Sid of a known peer, or -1
+func (node *Node) sid(id PeerID) int {
+	if peer, ok := node.peers[id]; ok {
+		return peer.Sid
+	}
+	return -1
+}
+
+// signPacket signs a symbol packet with the node key and returns its encoding
+func (node *Node) signPacket(packet *wire.SymbolPacket) ([]byte, error) {
+	signed, err := packet.SignedBytes()
+	if err != nil {
+		return nil, err
+	}
+	packet.Signature = ed25519.Sign(node.privKey, signed)
+	return packet.MarshalBinary()
+}
+
+// verifyPacket checks the signature of a symbol packet against the key of its sender
+func (node *Node) verifyPacket(packet *wire.SymbolPacket) bool {
+	if _, ok := node.peers[peerIDOf(packet.Sender)]; !ok && !node.AllowUnknownSenders {
+		atomic.AddUint64(&node.authStats.UnknownSender, 1)
+		return false
+	}
+	signed, err := packet.SignedBytes()
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(packet.Sender), signed, packet.Signature) {
+		atomic.AddUint64(&node.authStats.BadSignature, 1)
+		return false
+	}
+	atomic.AddUint64(&node.authStats.Verified, 1)
+	return true
+}
+
+// signAck signs an acknowledgement with the node key and returns its encoding
+func (node *Node) signAck(ack *wire.AckPacket) ([]byte, error) {
+	if len(node.privKey) != ed25519.PrivateKeySize {
+		return nil, errNoPrivateKey
+	}
+	signed, err := ack.SignedBytes()
+	if err != nil {
+		return nil, err
+	}
+	ack.Signature = ed25519.Sign(node.privKey, signed)
+	return ack.MarshalBinary()
+}
+
+// verifyAck checks that an acknowledgement comes from another member of AllPeers, unknown senders are never allowed
+func (node *Node) verifyAck(ack *wire.AckPacket) bool {
+	id := peerIDOf(ack.Peer)
+	if _, ok := node.peers[id]; !ok || id == node.selfID {
+		atomic.AddUint64(&node.authStats.BadAcks, 1)
+		return false
+	}
+	signed, err := ack.SignedBytes()
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(ack.Peer), signed, ack.Signature) {
+		atomic.AddUint64(&node.authStats.BadAcks, 1)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package coopcast
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+	"testing"
+)
+
+// TestParsePubKey checks that only hex encoded keys of the ed25519 size are accepted
+func TestParsePubKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		input string
+		valid bool
+	}{
+		{"valid", hex.EncodeToString(pub), true},
+		{"not hex", "zz" + hex.EncodeToString(pub)[2:], false},
+		{"short", hex.EncodeToString(pub[:ed25519.PublicKeySize-1]), false},
+		{"long", hex.EncodeToString(append(pub, 0)), false},
+		{"empty", "", false},
+	}
+	for _, test := range tests {
+		key, err := ParsePubKey(test.input)
+		if test.valid && (err != nil || !bytes.Equal(key, pub)) {
+			t.Errorf("%v: ParsePubKey() = %x, %v, want %x", test.name, key, err, pub)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%v: ParsePubKey() accepted %q", test.name, test.input)
+		}
+	}
+}
+
+// testSymbol returns a well formed symbol packet signed with key
+func testSymbol(t testing.TB, key ed25519.PrivateKey) *wire.SymbolPacket {
+	packet := &wire.SymbolPacket{
+		HashType:  byte(SHA256),
+		RootHash:  bytes.Repeat([]byte{1}, wire.HashSize),
+		Hop:       1,
+		Sender:    key.Public().(ed25519.PublicKey),
+		NumChunks: 1,
+		ChunkHash: bytes.Repeat([]byte{2}, wire.HashSize),
+		Symbol:    []byte("symbol"),
+	}
+	signed, err := packet.SignedBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet.Signature = ed25519.Sign(key, signed)
+	return packet
+}
+
+// TestVerifyPacket signs symbol packets and checks which changes the verification detects
+func TestVerifyPacket(t *testing.T) {
+	c := newTestCluster(t, 3, func(i int, opts *Options) { opts.AllowUnknownSenders = i == 2 })
+	_, stranger, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signed returns the packet of key signed by signer after change
+	signed := func(key ed25519.PrivateKey, signer *Node, change func(packet *wire.SymbolPacket)) *wire.SymbolPacket {
+		data, err := signer.signPacket(testSymbol(t, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var packet wire.SymbolPacket
+		if err := packet.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if change != nil {
+			change(&packet)
+		}
+		return &packet
+	}
+	strangerSigned := testSymbol(t, stranger)
+	tests := []struct {
+		name     string
+		receiver *Node
+		packet   *wire.SymbolPacket
+		stats    AuthStats
+	}{
+		{"valid", c.nodes[1], signed(c.keys[0], c.nodes[0], nil), AuthStats{Verified: 1}},
+		{"hop decreased by a relay", c.nodes[1], signed(c.keys[0], c.nodes[0], func(p *wire.SymbolPacket) { p.Hop = 0 }), AuthStats{Verified: 2}},
+		{"symbol changed", c.nodes[1], signed(c.keys[0], c.nodes[0], func(p *wire.SymbolPacket) { p.Symbol[0] ^= 1 }), AuthStats{Verified: 2, BadSignature: 1}},
+		{"root hash changed", c.nodes[1], signed(c.keys[0], c.nodes[0], func(p *wire.SymbolPacket) { p.RootHash[0] ^= 1 }), AuthStats{Verified: 2, BadSignature: 2}},
+		{"malformed root hash", c.nodes[1], signed(c.keys[0], c.nodes[0], func(p *wire.SymbolPacket) { p.RootHash = p.RootHash[1:] }), AuthStats{Verified: 2, BadSignature: 3}},
+		{"signed by another peer", c.nodes[1], signed(c.keys[2], c.nodes[0], nil), AuthStats{Verified: 2, BadSignature: 4}},
+		{"unknown sender", c.nodes[1], strangerSigned, AuthStats{Verified: 2, BadSignature: 4, UnknownSender: 1}},
+		{"unknown sender allowed", c.nodes[2], strangerSigned, AuthStats{Verified: 1}},
+	}
+	for _, test := range tests {
+		want := test.stats.Verified > test.receiver.AuthStats().Verified
+		if valid := test.receiver.verifyPacket(test.packet); valid != want {
+			t.Errorf("%v: verifyPacket() = %v, want %v", test.name, valid, want)
+		}
+		if stats := test.receiver.AuthStats(); stats != test.stats {
+			t.Errorf("%v: stats %+v, want %+v", test.name, stats, test.stats)
+		}
+	}
+}
+
+// TestPeerIdentity checks that peers are known by their public key and the node by its private key
+func TestPeerIdentity(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	node := c.nodes[1]
+	for i, key := range c.keys {
+		id := peerIDOf(key.Public().(ed25519.PublicKey))
+		if sid := node.sid(id); sid != i {
+			t.Errorf("sid of peer %v is %v", i, sid)
+		}
+		if id.String() != c.peers[i].PubKey {
+			t.Errorf("peer %v is printed as %v, want %v", i, id, c.peers[i].PubKey)
+		}
+	}
+	if node.selfID != peerIDOf(c.keys[1].Public().(ed25519.PublicKey)) {
+		t.Errorf("node identifies itself as %v, want %v", node.selfID, c.peers[1].PubKey)
+	}
+	if sid := node.sid(PeerID{}); sid != -1 {
+		t.Errorf("sid of an unknown peer is %v, want -1", sid)
+	}
+}
+
+// TestBroadCastWithoutKey checks that a node refuses to broadcast unsigned symbols
+func TestBroadCastWithoutKey(t *testing.T) {
+	c := newTestCluster(t, 2, func(i int, opts *Options) { opts.PrivKey = nil })
+	if _, err := c.nodes[0].BroadCast([]byte("message"), nil); err != errNoPrivateKey {
+		t.Errorf("BroadCast() = %v, want %v", err, errNoPrivateKey)
+	}
+}
@@ -0,0 +1,198 @@
+package coopcast
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// default limits of the decoder cache
+const (
+	defaultMaxMemory       int64 = 1 << 30
+	defaultMaxSenderMemory int64 = 256 << 20
+	defaultMaxSessions     int   = 1024
+)
+
+var errCacheFull = errors.New("decoder cache is full")
+
+// EvictionPolicy chooses the session dropped when the decoder cache exceeds one of its limits
+type EvictionPolicy int
+
+// eviction policies
+const (
+	EvictLRU              EvictionPolicy = iota // the session which received a new symbol the longest time ago
+	EvictOldestIncomplete                       // decoded sessions first, then the incomplete session started first
+)
+
+// CacheLimits bounds the memory used by the decoders of received messages.
+// A decoder is charged the transfer length of its chunk when it is created.
+type CacheLimits struct {
+	MaxMemory       int64 // decoders of every session, 1 GiB if unset
+	MaxSenderMemory int64 // decoders of the sessions of a single sender, 256 MiB if unset
+	MaxSessions     int   // sessions in the cache, 1024 if unset
+	Policy          EvictionPolicy
+}
+
+func (limits CacheLimits) orDefault() CacheLimits {
+	if limits.MaxMemory <= 0 {
+		limits.MaxMemory = defaultMaxMemory
+	}
+	if limits.MaxSenderMemory <= 0 {
+		limits.MaxSenderMemory = defaultMaxSenderMemory
+	}
+	if limits.MaxSessions <= 0 {
+		limits.MaxSessions = defaultMaxSessions
+	}
+	return limits
+}
+
+// CacheStats reports the usage of the decoder cache
+type CacheStats struct {
+	Sessions         int    // sessions in the cache
+	Memory           int64  // bytes charged to the decoders in the cache
+	EvictedSessions  uint64 // sessions dropped to make room for other ones
+	RejectedChunks   uint64 // chunks not decoded because no room could be made for them
+	DuplicateSymbols uint64 // symbols dropped because they were already received
+}
+
+// CacheStats returns a snapshot of the decoder cache usage
+func (node *Node) CacheStats() CacheStats {
+	node.mux.Lock()
+	sessions, memory := len(node.Cache), node.memory
+	node.mux.Unlock()
+	return CacheStats{
+		Sessions:         sessions,
+		Memory:           memory,
+		EvictedSessions:  atomic.LoadUint64(&node.cacheStats.EvictedSessions),
+		RejectedChunks:   atomic.LoadUint64(&node.cacheStats.RejectedChunks),
+		DuplicateSymbols: atomic.LoadUint64(&node.cacheStats.DuplicateSymbols),
+	}
+}
+
+// chunkMemory is the memory charged to the decoder of a chunk
+func chunkMemory(o oti) int64 {
+	return int64(o.transferLength())
+}
+
+// reserve charges cost bytes to a session, evicting other sessions when a memory limit would be exceeded
+func (node *Node) reserve(key SessionKey, raptorq *RaptorQImpl, cost int64) error {
+	var evicted []*RaptorQImpl
+	defer func() {
+		for _, raptorq := range evicted {
+			raptorq.release()
+		}
+	}()
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	if node.Cache[key] != raptorq {
+		// evicted meanwhile
+		return errCacheFull
+	}
+	for node.senderMemory[key.Sender]+cost > node.CacheLimits.MaxSenderMemory {
+		victim, ok := node.pickVictim(key, &key.Sender, true)
+		if !ok {
+			atomic.AddUint64(&node.cacheStats.RejectedChunks, 1)
+			return errCacheFull
+		}
+		evicted = append(evicted, node.evict(victim))
+	}
+	for node.memory+cost > node.CacheLimits.MaxMemory {
+		victim, ok := node.pickVictim(key, nil, true)
+		if !ok {
+			atomic.AddUint64(&node.cacheStats.RejectedChunks, 1)
+			return errCacheFull
+		}
+		evicted = append(evicted, node.evict(victim))
+	}
+	node.memory += cost
+	node.senderMemory[key.Sender] += cost
+	raptorq.memory += cost
+	return nil
+}
+
+// refund gives back bytes charged to a session
+func (node *Node) refund(key SessionKey, raptorq *RaptorQImpl, cost int64) {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.uncharge(key, raptorq, cost)
+}
+
+// uncharge gives back bytes charged to a session, caller must hold node.mux
+func (node *Node) uncharge(key SessionKey, raptorq *RaptorQImpl, cost int64) {
+	if cost > raptorq.memory {
+		cost = raptorq.memory
+	}
+	raptorq.memory -= cost
+	node.memory -= cost
+	node.senderMemory[key.Sender] -= cost
+	if node.senderMemory[key.Sender] <= 0 {
+		delete(node.senderMemory, key.Sender)
+	}
+}
+
+// makeRoom evicts sessions until a new one fits in MaxSessions, caller must hold node.mux
+func (node *Node) makeRoom() ([]*RaptorQImpl, bool) {
+	var evicted []*RaptorQImpl
+	for len(node.Cache) >= node.CacheLimits.MaxSessions {
+		victim, ok := node.pickVictim(SessionKey{}, nil, false)
+		if !ok {
+			return evicted, false
+		}
+		evicted = append(evicted, node.evict(victim))
+	}
+	return evicted, true
+}
+
+// pickVictim selects the session to evict by the eviction policy among the sessions of sender, or of every
+// sender if nil, excluding the session being charged. Caller must hold node.mux
+func (node *Node) pickVictim(exclude SessionKey, sender *PeerID, needMemory bool) (SessionKey, bool) {
+	var victim SessionKey
+	var found bool
+	var best int64
+	for k, v := range node.Cache {
+		if k == exclude || (sender != nil && k.Sender != *sender) {
+			continue
+		}
+		if needMemory && v.memory == 0 {
+			continue
+		}
+		var rank int64
+		switch node.CacheLimits.Policy {
+		case EvictOldestIncomplete:
+			// decoded sessions rank before every incomplete one
+			if success := atomic.LoadInt64(&v.successTime); success > 0 {
+				rank = success - int64(1<<62)
+			} else {
+				rank = v.initTime
+			}
+		default:
+			rank = atomic.LoadInt64(&v.lastActive)
+		}
+		if !found || rank < best {
+			victim, best, found = k, rank, true
+		}
+	}
+	return victim, found
+}
+
+// evict drops a session from the cache, it returns the session to release once node.mux is unlocked.
+// Caller must hold node.mux
+func (node *Node) evict(key SessionKey) *RaptorQImpl {
+	raptorq := node.Cache[key]
+	log.Printf("evicting message %x from sender %v, %v bytes", key.Root, key.Sender, raptorq.memory)
+	atomic.AddUint64(&node.cacheStats.EvictedSessions, 1)
+	node.uncharge(key, raptorq, raptorq.memory)
+	delete(node.Cache, key)
+	if atomic.LoadInt64(&raptorq.successTime) > 0 {
+		node.finished[key] = time.Now().UnixNano()
+	}
+	return raptorq
+}
+
+func (node *Node) isFinished(key SessionKey) bool {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	_, ok := node.finished[key]
+	return ok
+}
@@ -0,0 +1,132 @@
+package coopcast
+
+import "testing"
+
+// testSession returns the key of the message root of sender
+func testSession(sender, root byte) SessionKey {
+	return SessionKey{Sender: PeerID{sender}, Root: HashKey{root}}
+}
+
+// newBudgetNode returns a node with the given cache limits and sessions
+func newBudgetNode(t *testing.T, limits CacheLimits, keys ...SessionKey) *Node {
+	node := newTestCluster(t, 1, func(i int, opts *Options) { opts.CacheLimits = limits }).nodes[0]
+	for _, key := range keys {
+		if node.initRaptorQIfNotExist(SHA256, key) == nil {
+			t.Fatalf("session %x of sender %v not created", key.Root[0], key.Sender[0])
+		}
+	}
+	return node
+}
+
+// cached returns whether every key is in the cache
+func cached(node *Node, keys ...SessionKey) bool {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	for _, key := range keys {
+		if node.Cache[key] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// TestReserveSenderBudget checks that a sender exceeding its budget only evicts its own sessions
+func TestReserveSenderBudget(t *testing.T) {
+	a1, a2, b := testSession(1, 1), testSession(1, 2), testSession(2, 3)
+	node := newBudgetNode(t, CacheLimits{MaxMemory: 1000, MaxSenderMemory: 60, MaxSessions: 10}, a1, a2, b)
+	if err := node.reserve(a1, node.Cache[a1], 40); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.reserve(b, node.Cache[b], 40); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.reserve(a2, node.Cache[a2], 40); err != nil {
+		t.Fatal(err)
+	}
+	if cached(node, a1) || !cached(node, a2, b) {
+		t.Errorf("sender 1 going over its budget should evict its other session only")
+	}
+	if stats := node.CacheStats(); stats.Memory != 80 || stats.Sessions != 2 || stats.EvictedSessions != 1 {
+		t.Errorf("stats %+v, want 80 bytes in 2 sessions and 1 eviction", stats)
+	}
+	// no other session of the sender left to evict
+	if err := node.reserve(a2, node.Cache[a2], 30); err != errCacheFull {
+		t.Errorf("reserve() = %v, want %v", err, errCacheFull)
+	}
+	if stats := node.CacheStats(); stats.Memory != 80 || stats.RejectedChunks != 1 {
+		t.Errorf("stats %+v, want 80 bytes and 1 rejected chunk", stats)
+	}
+	node.refund(a2, node.Cache[a2], 40)
+	if err := node.reserve(a2, node.Cache[a2], 60); err != nil {
+		t.Errorf("reserve() after refund = %v", err)
+	}
+}
+
+// TestReserveGlobalBudget checks that the node budget evicts sessions of any sender, but never the one charged
+func TestReserveGlobalBudget(t *testing.T) {
+	a, b, c := testSession(1, 1), testSession(2, 2), testSession(3, 3)
+	node := newBudgetNode(t, CacheLimits{MaxMemory: 100, MaxSenderMemory: 200, MaxSessions: 10}, a, b, c)
+	node.Cache[a].lastActive, node.Cache[b].lastActive, node.Cache[c].lastActive = 2, 1, 3
+	evicted := node.Cache[b]
+	for _, key := range []SessionKey{a, b} {
+		if err := node.reserve(key, node.Cache[key], 40); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := node.reserve(c, node.Cache[c], 40); err != nil {
+		t.Fatal(err)
+	}
+	if cached(node, b) || !cached(node, a, c) {
+		t.Errorf("the least recently used session of another sender should be evicted")
+	}
+	// every other session is evicted and the chunk still does not fit
+	if err := node.reserve(c, node.Cache[c], 100); err != errCacheFull {
+		t.Errorf("reserve() over the node budget = %v, want %v", err, errCacheFull)
+	}
+	if cached(node, a) || !cached(node, c) {
+		t.Errorf("the session charged should be the only one left")
+	}
+	stats := node.CacheStats()
+	if stats.Memory != 40 || stats.EvictedSessions != 2 || stats.RejectedChunks != 1 {
+		t.Errorf("stats %+v, want 40 bytes, 2 evictions and 1 rejected chunk", stats)
+	}
+	if err := node.reserve(b, evicted, 10); err != errCacheFull {
+		t.Errorf("reserve() for an evicted session = %v, want %v", err, errCacheFull)
+	}
+}
+
+// TestEvictionOrder checks which session makes room for a new one under every eviction policy
+func TestEvictionOrder(t *testing.T) {
+	s1, s2, s3 := testSession(1, 1), testSession(2, 2), testSession(3, 3)
+	tests := []struct {
+		policy EvictionPolicy
+		order  []SessionKey // evicted by the next sessions
+	}{
+		{EvictLRU, []SessionKey{s2, s3, s1}},
+		{EvictOldestIncomplete, []SessionKey{s3, s1, s2}},
+	}
+	for _, test := range tests {
+		node := newBudgetNode(t, CacheLimits{MaxSessions: 3, Policy: test.policy}, s1, s2, s3)
+		// s3 is decoded, s1 started first, s2 received a symbol the longest time ago
+		node.Cache[s1].initTime, node.Cache[s1].lastActive = 1, 30
+		node.Cache[s2].initTime, node.Cache[s2].lastActive = 2, 10
+		node.Cache[s3].initTime, node.Cache[s3].lastActive, node.Cache[s3].successTime = 3, 20, 25
+		for i, want := range test.order {
+			next := testSession(10, byte(10+i))
+			if node.initRaptorQIfNotExist(SHA256, next) == nil {
+				t.Fatalf("policy %v: session %v not created", test.policy, i)
+			}
+			// the new sessions are the most recent and the newest incomplete ones
+			node.Cache[next].initTime, node.Cache[next].lastActive = int64(100+i), int64(100+i)
+			if cached(node, want) {
+				t.Errorf("policy %v: session of sender %v not evicted by session %v", test.policy, want.Sender[0], i)
+			}
+		}
+		if stats := node.CacheStats(); stats.Sessions != 3 || stats.EvictedSessions != 3 {
+			t.Errorf("policy %v: stats %+v, want 3 sessions and 3 evictions", test.policy, stats)
+		}
+		if !node.isFinished(s3) || node.isFinished(s1) {
+			t.Errorf("policy %v: only the decoded session should be remembered as finished", test.policy)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package coopcast
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"github.com/harmony-one/libunison/internal/ida/transport"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testCluster is a set of fully connected nodes over a MemoryNetwork
+type testCluster struct {
+	network *transport.MemoryNetwork
+	peers   []Peer
+	keys    []ed25519.PrivateKey
+	nodes   []*Node
+	conns   []net.PacketConn
+}
+
+// newTestCluster creates n nodes, configure may change the options of every node before it is created.
+// The nodes are not started.
+func newTestCluster(t testing.TB, n int, configure func(i int, opts *Options)) *testCluster {
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	c := &testCluster{network: transport.NewMemoryNetwork()}
+	for i := 0; i < n; i++ {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.keys = append(c.keys, priv)
+		c.peers = append(c.peers, Peer{IP: "127.0.0.1", TCPPort: strconv.Itoa(20000 + i), UDPPort: strconv.Itoa(30000 + i), Sid: i, PubKey: hex.EncodeToString(pub)})
+	}
+	for i := 0; i < n; i++ {
+		var neighbors []Peer
+		for j, peer := range c.peers {
+			if j != i {
+				neighbors = append(neighbors, peer)
+			}
+		}
+		opts := Options{
+			SelfPeer:         c.peers[i],
+			PeerList:         neighbors,
+			AllPeers:         c.peers,
+			PrivKey:          c.keys[i],
+			InitialDelayTime: 1,
+			MaxDelayTime:     5,
+			ExpBase:          1.05,
+			RelayTime:        0.1,
+			Hop:              1,
+			Transport:        c.network,
+		}
+		if configure != nil {
+			configure(i, &opts)
+		}
+		c.nodes = append(c.nodes, NewNode(opts))
+	}
+	return c
+}
+
+// listen opens the UDP port of the i-th peer
+func (c *testCluster) listen(i int) (net.PacketConn, error) {
+	return c.network.ListenPacket(":" + c.peers[i].UDPPort)
+}
+
+// start listens on the UDP port of every node and starts it
+func (c *testCluster) start(t testing.TB) {
+	for i, node := range c.nodes {
+		pc, err := c.listen(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.conns = append(c.conns, pc)
+		if err := node.Start(context.Background(), pc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// close stops every node and closes its connection
+func (c *testCluster) close() {
+	for _, node := range c.nodes {
+		node.Close()
+	}
+	for _, pc := range c.conns {
+		pc.Close()
+	}
+}
+
+// waitGoroutines fails the test if more than n goroutines are still running after a grace period
+func waitGoroutines(t testing.TB, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%v goroutines running, %v before the test\n%s", runtime.NumGoroutine(), n, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// eventually fails the test if cond does not hold within a few seconds
+func eventually(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
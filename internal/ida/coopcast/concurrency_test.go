@@ -0,0 +1,100 @@
+package coopcast
+
+import (
+	"crypto/sha256"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentBroadcasts runs broadcasts from every node at once and checks that every node delivers every
+// message of the others exactly once, and that closing the nodes stops all their goroutines
+func TestConcurrentBroadcasts(t *testing.T) {
+	const (
+		numNodes    = 5
+		perSender   = 2
+		messageSize = 48 << 10
+	)
+	goroutines := runtime.NumGoroutine()
+	c := newTestCluster(t, numNodes, nil)
+
+	var mux sync.Mutex
+	delivered := make([]map[[sha256.Size]byte]int, numNodes) // payload digest to deliveries
+	broadcast := make(map[[sha256.Size]byte]int)             // payload digest to sender
+	for i, node := range c.nodes {
+		i := i
+		delivered[i] = make(map[[sha256.Size]byte]int)
+		node.OnMessage(func(msg Message) {
+			mux.Lock()
+			defer mux.Unlock()
+			delivered[i][sha256.Sum256(msg.Payload)]++
+		})
+	}
+	c.start(t)
+
+	var wg sync.WaitGroup
+	handles := make(chan BroadCastHandle, numNodes*perSender)
+	for i := range c.nodes {
+		for k := 0; k < perSender; k++ {
+			msg := make([]byte, messageSize+rand.Intn(messageSize))
+			rand.Read(msg)
+			broadcast[sha256.Sum256(msg)] = i
+			i := i
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h, err := c.nodes[i].BroadCast(msg, c.conns[i])
+				if err != nil {
+					t.Errorf("node %v broadcast: %v", i, err)
+					return
+				}
+				handles <- h
+			}()
+		}
+	}
+	wg.Wait()
+	close(handles)
+	for h := range handles {
+		if err := h.Wait(); err != nil {
+			t.Errorf("broadcast failed: %v", err)
+		}
+	}
+
+	// the handles complete on the acknowledgements, the messages may still be on their way to the handlers
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mux.Lock()
+		complete := true
+		for i := range delivered {
+			complete = complete && len(delivered[i]) == (numNodes-1)*perSender
+		}
+		mux.Unlock()
+		if complete || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.close()
+
+	mux.Lock()
+	defer mux.Unlock()
+	for i := range delivered {
+		if len(delivered[i]) != (numNodes-1)*perSender {
+			t.Errorf("node %v delivered %v messages, want %v", i, len(delivered[i]), (numNodes-1)*perSender)
+		}
+		for digest, n := range delivered[i] {
+			sender, ok := broadcast[digest]
+			switch {
+			case !ok:
+				t.Errorf("node %v delivered a message nobody broadcast", i)
+			case sender == i:
+				t.Errorf("node %v delivered its own message", i)
+			case n != 1:
+				t.Errorf("node %v delivered a message of node %v %v times", i, sender, n)
+			}
+		}
+	}
+	waitGoroutines(t, goroutines)
+}
@@ -0,0 +1,68 @@
+package coopcast
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+)
+
+// OnMessage registers a handler which is called for every message decoded by the node
+func (node *Node) OnMessage(handler MessageHandler) {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.handlers = append(node.handlers, handler)
+}
+
+func (node *Node) deliver(msg Message) {
+	node.mux.Lock()
+	handlers := make([]MessageHandler, len(node.handlers))
+	copy(handlers, node.handlers)
+	node.mux.Unlock()
+	atomic.AddUint64(&node.deliveryStats.Delivered, 1)
+	if len(handlers) == 0 {
+		log.Printf("message %x decoded but no handler registered", msg.RootHash)
+	}
+	for _, handler := range handlers {
+		handler(msg)
+	}
+}
+
+// OnChunk registers a handler which is called for every chunk decoded by the node, before the whole message is.
+// It lets applications start processing the early chunks of a message while the later ones are still in flight.
+func (node *Node) OnChunk(handler ChunkHandler) {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.chunkHandlers = append(node.chunkHandlers, handler)
+}
+
+func (node *Node) deliverChunk(chunk Chunk) {
+	node.mux.Lock()
+	handlers := make([]ChunkHandler, len(node.chunkHandlers))
+	copy(handlers, node.chunkHandlers)
+	node.mux.Unlock()
+	for _, handler := range handlers {
+		handler(chunk)
+	}
+}
+
+// FileSink returns a MessageHandler which writes every delivered message into dir as <senderID>_<successTime>,
+// the hex encoded public key replaces the id of unknown senders
+func FileSink(dir string) MessageHandler {
+	return func(msg Message) {
+		if msg.Payload == nil {
+			// already written by a StreamOpener
+			return
+		}
+		sender := strconv.Itoa(msg.SenderID)
+		if msg.SenderID < 0 {
+			sender = msg.Sender.String()
+		}
+		fileloc := filepath.Join(dir, sender+"_"+strconv.FormatUint(uint64(msg.SuccessTime), 10))
+		err := ioutil.WriteFile(fileloc, msg.Payload, 0644)
+		if err != nil {
+			log.Printf("unable to write file %v to disk", fileloc)
+		}
+	}
+}
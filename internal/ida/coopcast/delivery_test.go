@@ -0,0 +1,83 @@
+package coopcast
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestDeliverCallsEveryHandler checks that a delivered message reaches every registered handler in order
+func TestDeliverCallsEveryHandler(t *testing.T) {
+	node := &Node{}
+	msg := Message{Payload: []byte("payload"), RootHash: []byte("root"), SenderID: 3}
+	var calls []int
+	for i := 0; i < 3; i++ {
+		i := i
+		node.OnMessage(func(got Message) {
+			if !bytes.Equal(got.Payload, msg.Payload) || got.SenderID != msg.SenderID {
+				t.Errorf("handler %v got %+v, want %+v", i, got, msg)
+			}
+			calls = append(calls, i)
+		})
+	}
+	node.deliver(msg)
+	if len(calls) != 3 || calls[0] != 0 || calls[1] != 1 || calls[2] != 2 {
+		t.Errorf("handlers called in order %v, want [0 1 2]", calls)
+	}
+}
+
+// TestFileSink checks that FileSink writes the payload into a file named after the sender and decode time
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	FileSink(dir)(Message{Payload: []byte("payload"), SenderID: 3, SuccessTime: 42})
+	data, err := ioutil.ReadFile(filepath.Join(dir, "3_42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "payload" {
+		t.Errorf("file contains %q, want %q", data, "payload")
+	}
+}
+
+// TestBroadCastDelivers broadcasts a message between running nodes and checks that every other node delivers it
+func TestBroadCastDelivers(t *testing.T) {
+	const numNodes = 3
+	c := newTestCluster(t, numNodes, nil)
+	delivered := make(chan Message, numNodes)
+	for _, node := range c.nodes {
+		node.OnMessage(func(msg Message) { delivered <- msg })
+	}
+	c.start(t)
+	defer c.close()
+
+	msg := make([]byte, 50<<10)
+	rand.Read(msg)
+	h, err := c.nodes[0].BroadCast(msg, c.conns[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	for i := 1; i < numNodes; i++ {
+		select {
+		case got := <-delivered:
+			if !bytes.Equal(got.Payload, msg) {
+				t.Errorf("delivered payload differs from the broadcast message")
+			}
+			if got.SenderID != 0 {
+				t.Errorf("delivered message of sender %v, want 0", got.SenderID)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%v of %v nodes delivered the message", i-1, numNodes-1)
+		}
+	}
+	select {
+	case <-delivered:
+		t.Errorf("message delivered more than once")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
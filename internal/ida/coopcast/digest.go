@@ -0,0 +1,137 @@
+package coopcast
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// HashType identifies the digest used for message and chunk hashes, it is carried as the first byte of every packet
+type HashType byte
+
+// supported digests, all of them produce hashSize bytes
+const (
+	SHA256     HashType = 1
+	BLAKE2b256 HashType = 2
+)
+
+// domain separation of the merkle tree leaves and inner nodes
+const (
+	merkleLeaf byte = 0
+	merkleNode byte = 1
+)
+
+func (h HashType) String() string {
+	switch h {
+	case SHA256:
+		return "sha256"
+	case BLAKE2b256:
+		return "blake2b-256"
+	}
+	return fmt.Sprintf("HashType(%d)", byte(h))
+}
+
+// ParseHashType returns the HashType named s, as printed by HashType.String
+func ParseHashType(s string) (HashType, error) {
+	for _, h := range []HashType{SHA256, BLAKE2b256} {
+		if h.String() == s {
+			return h, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash type %v", s)
+}
+
+// Valid reports whether h is a supported digest
+func (h HashType) Valid() bool {
+	return h == SHA256 || h == BLAKE2b256
+}
+
+// Sum returns the digest of the concatenation of data
+func (h HashType) Sum(data ...[]byte) []byte {
+	switch h {
+	case BLAKE2b256:
+		d, _ := blake2b.New256(nil)
+		for _, b := range data {
+			d.Write(b)
+		}
+		return d.Sum(nil)
+	default:
+		d := sha256.New()
+		for _, b := range data {
+			d.Write(b)
+		}
+		return d.Sum(nil)
+	}
+}
+
+// chunkHash returns the merkle leaf of a chunk
+func (h HashType) chunkHash(chunk []byte) []byte {
+	return h.Sum([]byte{merkleLeaf}, chunk)
+}
+
+// merkleRoot computes the root of the merkle tree over the chunk hashes,
+// an odd node at the end of a level is promoted unchanged
+func (h HashType) merkleRoot(leaves [][]byte) []byte {
+	level := leaves
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, h.Sum([]byte{merkleNode}, level[i], level[i+1]))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// merkleProof returns the sibling hashes from the leaf at index up to the root
+func (h HashType) merkleProof(leaves [][]byte, index int) [][]byte {
+	var proof [][]byte
+	level := leaves
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, h.Sum([]byte{merkleNode}, level[i], level[i+1]))
+		}
+		level = next
+		index /= 2
+	}
+	return proof
+}
+
+// verifyMerkleProof checks that leaf is the chunk at index of a message with numLeaves chunks and the given root
+func (h HashType) verifyMerkleProof(root []byte, leaf []byte, index int, numLeaves int, proof [][]byte) bool {
+	if index < 0 || index >= numLeaves {
+		return false
+	}
+	node := leaf
+	for width := numLeaves; width > 1; width = (width + 1) / 2 {
+		sibling := index ^ 1
+		if sibling < width {
+			if len(proof) == 0 {
+				return false
+			}
+			if index%2 == 0 {
+				node = h.Sum([]byte{merkleNode}, node, proof[0])
+			} else {
+				node = h.Sum([]byte{merkleNode}, proof[0], node)
+			}
+			proof = proof[1:]
+		}
+		index /= 2
+	}
+	return len(proof) == 0 && bytes.Equal(node, root)
+}
@@ -0,0 +1,116 @@
+package coopcast
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// testLeaves returns the chunk hashes of n distinct chunks
+func testLeaves(h HashType, n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = h.chunkHash([]byte(strconv.Itoa(i)))
+	}
+	return leaves
+}
+
+// TestMerkleProofRoundTrip checks that the proof of every leaf verifies against the root, for even and odd sizes
+func TestMerkleProofRoundTrip(t *testing.T) {
+	for _, h := range []HashType{SHA256, BLAKE2b256} {
+		for n := 1; n <= 17; n++ {
+			leaves := testLeaves(h, n)
+			root := h.merkleRoot(leaves)
+			if len(root) != hashSize {
+				t.Fatalf("%v: root has %v bytes, want %v", h, len(root), hashSize)
+			}
+			for i := range leaves {
+				proof := h.merkleProof(leaves, i)
+				if !h.verifyMerkleProof(root, leaves[i], i, n, proof) {
+					t.Errorf("%v n=%v: proof of leaf %v does not verify", h, n, i)
+				}
+			}
+		}
+	}
+}
+
+// TestMerkleRootSingleChunk checks that the root of a single chunk message is its chunk hash and needs no proof
+func TestMerkleRootSingleChunk(t *testing.T) {
+	for _, h := range []HashType{SHA256, BLAKE2b256} {
+		leaves := testLeaves(h, 1)
+		if root := h.merkleRoot(leaves); !bytes.Equal(root, leaves[0]) {
+			t.Errorf("%v: root %x, want the chunk hash %x", h, root, leaves[0])
+		}
+		if proof := h.merkleProof(leaves, 0); len(proof) != 0 {
+			t.Errorf("%v: proof has %v hashes, want none", h, len(proof))
+		}
+	}
+}
+
+// TestMerkleProofRejects checks that a proof only verifies for its own leaf, index, size and root
+func TestMerkleProofRejects(t *testing.T) {
+	for _, h := range []HashType{SHA256, BLAKE2b256} {
+		const n, index = 7, 4
+		leaves := testLeaves(h, n)
+		root := h.merkleRoot(leaves)
+		proof := h.merkleProof(leaves, index)
+		tampered := func(i int) [][]byte {
+			p := make([][]byte, len(proof))
+			copy(p, proof)
+			p[i] = append([]byte(nil), p[i]...)
+			p[i][0] ^= 1
+			return p
+		}
+		tests := []struct {
+			name      string
+			root      []byte
+			leaf      []byte
+			index     int
+			numLeaves int
+			proof     [][]byte
+		}{
+			{"tampered first sibling", root, leaves[index], index, n, tampered(0)},
+			{"tampered last sibling", root, leaves[index], index, n, tampered(len(proof) - 1)},
+			{"other leaf", root, leaves[index-1], index, n, proof},
+			{"wrong index", root, leaves[index], index + 1, n, proof},
+			{"negative index", root, leaves[index], -1, n, proof},
+			{"index out of range", root, leaves[index], n, n, proof},
+			{"wrong number of leaves", root, leaves[index], index, n + 2, proof},
+			{"proof too short", root, leaves[index], index, n, proof[:len(proof)-1]},
+			{"proof too long", root, leaves[index], index, n, append(proof[:len(proof):len(proof)], leaves[0])},
+			{"no proof", root, leaves[index], index, n, nil},
+			{"other root", h.merkleRoot(leaves[:n-1]), leaves[index], index, n, proof},
+			{"other digest", h.merkleRoot(testLeaves(otherHashType(h), n)), leaves[index], index, n, proof},
+		}
+		for _, test := range tests {
+			if h.verifyMerkleProof(test.root, test.leaf, test.index, test.numLeaves, test.proof) {
+				t.Errorf("%v: %v: proof verified", h, test.name)
+			}
+		}
+	}
+}
+
+func otherHashType(h HashType) HashType {
+	if h == SHA256 {
+		return BLAKE2b256
+	}
+	return SHA256
+}
+
+// TestParseHashType checks that every digest parses back from its name
+func TestParseHashType(t *testing.T) {
+	for _, h := range []HashType{SHA256, BLAKE2b256} {
+		if parsed, err := ParseHashType(h.String()); err != nil || parsed != h {
+			t.Errorf("ParseHashType(%q) = %v, %v, want %v", h.String(), parsed, err, h)
+		}
+		if !h.Valid() {
+			t.Errorf("%v is not valid", h)
+		}
+	}
+	if _, err := ParseHashType("sha1"); err == nil {
+		t.Errorf("ParseHashType(sha1) succeeded")
+	}
+	if HashType(0).Valid() || HashType(3).Valid() {
+		t.Errorf("unknown hash types are valid")
+	}
+}
@@ -0,0 +1,93 @@
+package coopcast
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+)
+
+// maxSourceSymbols is the largest number of source symbols of a source block allowed by RFC6330
+const maxSourceSymbols = 56403
+
+// EncodingParams controls how a message is split into chunks and how every chunk is RaptorQ encoded
+type EncodingParams struct {
+	SymbolSize uint16 // T, bytes of payload per packet, must be multiple of Alignment
+	Alignment  uint8  // Al, symbol alignment parameter
+	SubBlocks  uint16 // N, number of sub-blocks of a chunk, SymbolSize/SubBlocks must be multiple of Alignment
+	ChunkSize  uint32 // bytes per chunk, every chunk is encoded as an independent source object
+}
+
+// DefaultEncodingParams fits a symbol packet into an ethernet frame
+func DefaultEncodingParams() EncodingParams {
+	return EncodingParams{SymbolSize: 1200, Alignment: 4, SubBlocks: 1, ChunkSize: 100 * 1200}
+}
+
+// Validate checks the parameters against the RFC6330 and wire format restrictions
+func (p EncodingParams) Validate() error {
+	if p.SymbolSize == 0 || p.Alignment == 0 || p.SubBlocks == 0 || p.ChunkSize == 0 {
+		return errors.New("encoding parameters must be positive")
+	}
+	if p.SymbolSize%uint16(p.Alignment) != 0 {
+		return fmt.Errorf("symbol size %v is not a multiple of alignment %v", p.SymbolSize, p.Alignment)
+	}
+	if p.SymbolSize%p.SubBlocks != 0 || (p.SymbolSize/p.SubBlocks)%uint16(p.Alignment) != 0 {
+		return fmt.Errorf("symbol size %v cannot be split into %v sub-symbols aligned to %v", p.SymbolSize, p.SubBlocks, p.Alignment)
+	}
+	if int(p.SymbolSize)+wire.MinSymbolPacketSize+wire.MaxProofLen*wire.HashSize > udpCacheSize {
+		return fmt.Errorf("symbol size %v does not fit in a datagram", p.SymbolSize)
+	}
+	if (uint64(p.ChunkSize)+uint64(p.SymbolSize)-1)/uint64(p.SymbolSize) > maxSourceSymbols {
+		return fmt.Errorf("chunk size %v needs more than %v symbols of %v bytes", p.ChunkSize, maxSourceSymbols, p.SymbolSize)
+	}
+	return nil
+}
+
+// orDefault returns the default parameters in place of the zero value
+func (p EncodingParams) orDefault() EncodingParams {
+	if p == (EncodingParams{}) {
+		return DefaultEncodingParams()
+	}
+	return p
+}
+
+// oti is the RFC6330 object transmission information of a chunk encoder:
+// CommonOTI = |Transfer Length(5)|Reserved(1)|Symbol Size(2)|, SchemeSpecificOTI = |Z(1)|N(2)|Al(1)|
+type oti struct {
+	common         uint64
+	schemeSpecific uint32
+}
+
+func (o oti) transferLength() uint64 { return o.common >> 24 }
+func (o oti) symbolSize() uint16     { return uint16(o.common) }
+func (o oti) sourceBlocks() uint8    { return uint8(o.schemeSpecific >> 24) }
+func (o oti) subBlocks() uint16      { return uint16(o.schemeSpecific >> 8) }
+func (o oti) alignment() uint8       { return uint8(o.schemeSpecific) }
+
+// validate checks the OTI of a chunk against its symbol packet
+func (o oti) validate(packet *wire.SymbolPacket, last bool) error {
+	params := EncodingParams{SymbolSize: o.symbolSize(), Alignment: o.alignment(), SubBlocks: o.subBlocks(), ChunkSize: packet.ChunkSize}
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	if int(o.symbolSize()) != len(packet.Symbol) {
+		return fmt.Errorf("symbol of %v bytes, OTI symbol size is %v", len(packet.Symbol), o.symbolSize())
+	}
+	if o.sourceBlocks() != 1 {
+		return fmt.Errorf("chunk encoded in %v source blocks", o.sourceBlocks())
+	}
+	F := o.transferLength()
+	if F == 0 || F > uint64(packet.ChunkSize) || (!last && F != uint64(packet.ChunkSize)) {
+		return fmt.Errorf("transfer length %v does not match chunk size %v", F, packet.ChunkSize)
+	}
+	return nil
+}
+
+// BroadCastOptions holds the parameters of a single broadcast
+type BroadCastOptions struct {
+	Encoding EncodingParams // node encoding parameters if unset
+	Window   int            // number of chunks broadcast concurrently, the encoders of other chunks are not created, 8 if unset
+	Timeout  time.Duration  // the broadcast stops with ErrBroadCastTimeout after it, 100 seconds if unset
+	Pacing   PacingOptions  // node pacing if unset
+}
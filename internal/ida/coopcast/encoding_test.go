@@ -0,0 +1,127 @@
+package coopcast
+
+import (
+	"bytes"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TestEncodingParamsValidate checks the RFC6330 and datagram restrictions on the encoding parameters
+func TestEncodingParamsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		params EncodingParams
+		valid  bool
+	}{
+		{"default", DefaultEncodingParams(), true},
+		{"small symbols", EncodingParams{SymbolSize: 64, Alignment: 4, SubBlocks: 1, ChunkSize: 1 << 10}, true},
+		{"sub-blocks", EncodingParams{SymbolSize: 1200, Alignment: 4, SubBlocks: 10, ChunkSize: 1 << 20}, true},
+		{"jumbo symbols", EncodingParams{SymbolSize: 8192, Alignment: 8, SubBlocks: 1, ChunkSize: 1 << 20}, true},
+		{"largest chunk", EncodingParams{SymbolSize: 1200, Alignment: 4, SubBlocks: 1, ChunkSize: maxSourceSymbols * 1200}, true},
+		{"zero symbol size", EncodingParams{Alignment: 4, SubBlocks: 1, ChunkSize: 1 << 10}, false},
+		{"zero alignment", EncodingParams{SymbolSize: 1200, SubBlocks: 1, ChunkSize: 1 << 10}, false},
+		{"zero sub-blocks", EncodingParams{SymbolSize: 1200, Alignment: 4, ChunkSize: 1 << 10}, false},
+		{"zero chunk size", EncodingParams{SymbolSize: 1200, Alignment: 4, SubBlocks: 1}, false},
+		{"misaligned symbol size", EncodingParams{SymbolSize: 1202, Alignment: 4, SubBlocks: 1, ChunkSize: 1 << 10}, false},
+		{"sub-blocks do not divide the symbol", EncodingParams{SymbolSize: 1200, Alignment: 4, SubBlocks: 7, ChunkSize: 1 << 10}, false},
+		{"misaligned sub-symbols", EncodingParams{SymbolSize: 1200, Alignment: 4, SubBlocks: 200, ChunkSize: 1 << 10}, false},
+		{"symbol larger than a datagram", EncodingParams{SymbolSize: 65532, Alignment: 4, SubBlocks: 1, ChunkSize: 1 << 20}, false},
+		{"too many source symbols", EncodingParams{SymbolSize: 1200, Alignment: 4, SubBlocks: 1, ChunkSize: maxSourceSymbols*1200 + 1}, false},
+	}
+	for _, test := range tests {
+		if err := test.params.Validate(); (err == nil) != test.valid {
+			t.Errorf("%v: Validate() = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+	if (EncodingParams{}).orDefault() != DefaultEncodingParams() {
+		t.Errorf("zero parameters do not default to DefaultEncodingParams")
+	}
+}
+
+// testOTI returns the OTI of a chunk of F bytes encoded in z source blocks with params
+func testOTI(F uint64, z uint8, params EncodingParams) oti {
+	return oti{
+		common:         F<<24 | uint64(params.SymbolSize),
+		schemeSpecific: uint32(z)<<24 | uint32(params.SubBlocks)<<8 | uint32(params.Alignment),
+	}
+}
+
+// TestOTIValidate checks the OTI of received symbols against the RFC6330 restrictions and their packet
+func TestOTIValidate(t *testing.T) {
+	params := EncodingParams{SymbolSize: 1200, Alignment: 4, SubBlocks: 1, ChunkSize: 12000}
+	with := func(change func(p *EncodingParams)) EncodingParams {
+		p := params
+		change(&p)
+		return p
+	}
+	tests := []struct {
+		name      string
+		oti       oti
+		chunkSize uint32
+		symbol    int
+		last      bool
+		valid     bool
+	}{
+		{"full chunk", testOTI(12000, 1, params), 12000, 1200, false, true},
+		{"short last chunk", testOTI(100, 1, params), 12000, 1200, true, true},
+		{"full last chunk", testOTI(12000, 1, params), 12000, 1200, true, true},
+		{"sub-blocks", testOTI(12000, 1, with(func(p *EncodingParams) { p.SubBlocks = 10 })), 12000, 1200, false, true},
+		{"misaligned symbol size", testOTI(12000, 1, with(func(p *EncodingParams) { p.SymbolSize = 1202 })), 12000, 1202, false, false},
+		{"invalid sub-block split", testOTI(12000, 1, with(func(p *EncodingParams) { p.SubBlocks = 200 })), 12000, 1200, false, false},
+		{"zero alignment", testOTI(12000, 1, with(func(p *EncodingParams) { p.Alignment = 0 })), 12000, 1200, false, false},
+		{"too many source symbols", testOTI(maxSourceSymbols*1200+1, 1, params), maxSourceSymbols*1200 + 1, 1200, false, false},
+		{"symbol shorter than the symbol size", testOTI(12000, 1, params), 12000, 1196, false, false},
+		{"no source block", testOTI(12000, 0, params), 12000, 1200, false, false},
+		{"several source blocks", testOTI(12000, 2, params), 12000, 1200, false, false},
+		{"empty chunk", testOTI(0, 1, params), 12000, 1200, true, false},
+		{"chunk larger than the chunk size", testOTI(12001, 1, params), 12000, 1200, true, false},
+		{"short chunk not last", testOTI(11999, 1, params), 12000, 1200, false, false},
+	}
+	for _, test := range tests {
+		packet := &wire.SymbolPacket{ChunkSize: test.chunkSize, Symbol: make([]byte, test.symbol)}
+		if err := test.oti.validate(packet, test.last); (err == nil) != test.valid {
+			t.Errorf("%v: validate() = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+// TestBroadCastWithEncoding broadcasts a message of several chunks with non default encoding parameters
+func TestBroadCastWithEncoding(t *testing.T) {
+	const numNodes = 3
+	c := newTestCluster(t, numNodes, nil)
+	delivered := make(chan Message, numNodes)
+	for _, node := range c.nodes {
+		node.OnMessage(func(msg Message) { delivered <- msg })
+	}
+	c.start(t)
+	defer c.close()
+
+	if _, err := c.nodes[0].BroadCastWith([]byte("message"), c.conns[0], BroadCastOptions{Encoding: EncodingParams{SymbolSize: 1202, Alignment: 4, SubBlocks: 1, ChunkSize: 1 << 10}}); err == nil {
+		t.Errorf("BroadCastWith() accepted invalid encoding parameters")
+	}
+	msg := make([]byte, 20<<10+100)
+	rand.Read(msg)
+	encoding := EncodingParams{SymbolSize: 512, Alignment: 8, SubBlocks: 2, ChunkSize: 8 << 10}
+	h, err := c.nodes[0].BroadCastWith(msg, c.conns[0], BroadCastOptions{Encoding: encoding})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	if stats := h.Stats(); stats.NumChunks != 3 {
+		t.Errorf("message sent in %v chunks, want 3", stats.NumChunks)
+	}
+	for i := 1; i < numNodes; i++ {
+		select {
+		case got := <-delivered:
+			if !bytes.Equal(got.Payload, msg) {
+				t.Errorf("delivered payload differs from the broadcast message")
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%v of %v nodes delivered the message", i-1, numNodes-1)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package coopcast
+
+import (
+	"context"
+	"fmt"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/udpbatch"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const defaultFanout int = 4
+
+// FanoutPolicy chooses the peers the received symbols are relayed to
+type FanoutPolicy int
+
+// fanout policies
+const (
+	FanoutAll        FanoutPolicy = iota // every neighbor, starting from a random one
+	FanoutRandom                         // Fanout neighbors drawn at random for every batch of symbols
+	FanoutUnfinished                     // the neighbors which did not announce they decoded the chunk of the symbol
+	FanoutTree                           // the children of the node in a spanning tree of AllPeers, see FanoutOptions
+)
+
+func (p FanoutPolicy) String() string {
+	switch p {
+	case FanoutAll:
+		return "all"
+	case FanoutRandom:
+		return "random"
+	case FanoutUnfinished:
+		return "unfinished"
+	case FanoutTree:
+		return "tree"
+	}
+	return fmt.Sprintf("FanoutPolicy(%d)", int(p))
+}
+
+// ParseFanoutPolicy returns the FanoutPolicy named s, as printed by FanoutPolicy.String
+func ParseFanoutPolicy(s string) (FanoutPolicy, error) {
+	for _, policy := range []FanoutPolicy{FanoutAll, FanoutRandom, FanoutUnfinished, FanoutTree} {
+		if policy.String() == s {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown fanout policy %v", s)
+}
+
+// FanoutOptions selects the peers a node relays the received symbols to, trading redundancy against bandwidth.
+//
+// With FanoutUnfinished, a node announces every chunk it decoded to its neighbors with its signed acknowledgement,
+// so the policy only saves the symbols sent to the neighbors running it too.
+//
+// With FanoutTree, the symbols of a sender are relayed along a tree of depth Hop over AllPeers but the sender,
+// so that every peer receives the symbol once. The sender gives the symbol to the root of its tree, the peer the
+// symbol ID selects in the ring of AllPeers but the sender. The peers of the tree are not necessarily neighbors,
+// and every node must use the same Hop, AllPeers and policy.
+type FanoutOptions struct {
+	Policy FanoutPolicy
+	Fanout int // neighbors of FanoutRandom, 4 if unset
+}
+
+func (opts FanoutOptions) orDefault() FanoutOptions {
+	if opts.Fanout <= 0 {
+		opts.Fanout = defaultFanout
+	}
+	return opts
+}
+
+// relayTarget is a peer a batch of symbols is relayed to, packets are the indices of the symbols in the batch
+type relayTarget struct {
+	addr    net.Addr
+	packets []int
+	hop     int // remaining hops of the relayed symbols, the received ones minus one if negative
+}
+
+// treeChild is a child of the node in the relay tree of a symbol
+type treeChild struct {
+	peer int // index in AllPeers
+	hop  int
+}
+
+// neighborChunks records the neighbors which announced they decoded the chunks of a message
+type neighborChunks struct {
+	decoded map[int]map[int]bool // chunkID to the indices in PeerList of the neighbors which decoded it
+	updated int64                // last announcement, UnixNano time
+}
+
+// fanout returns the peers a batch of received symbols is relayed to, in the order they are sent to.
+// The relay tree spans AllPeers, the other policies relay to the neighbors only
+func (node *Node) fanout(packets []*wire.SymbolPacket) []relayTarget {
+	if !node.hasRelayPeers() {
+		return nil
+	}
+	all := make([]int, len(packets))
+	for j := range all {
+		all[j] = j
+	}
+	switch node.Fanout.Policy {
+	case FanoutRandom:
+		var targets []relayTarget
+		for _, i := range rand.Perm(len(node.peerAddrs)) {
+			if len(targets) == node.Fanout.Fanout {
+				break
+			}
+			if addr := node.peerAddrs[i]; addr != nil {
+				targets = append(targets, relayTarget{addr: addr, packets: all, hop: -1})
+			}
+		}
+		return targets
+	case FanoutUnfinished:
+		return node.unfinishedTargets(packets)
+	case FanoutTree:
+		return node.treeTargets(packets)
+	}
+	targets := make([]relayTarget, 0, len(node.peerAddrs))
+	idx0 := rand.Intn(len(node.peerAddrs))
+	for i := range node.peerAddrs {
+		if addr := node.peerAddrs[(i+idx0)%len(node.peerAddrs)]; addr != nil {
+			targets = append(targets, relayTarget{addr: addr, packets: all, hop: -1})
+		}
+	}
+	return targets
+}
+
+// hasRelayPeers reports whether the fanout policy of the node has peers to relay to
+func (node *Node) hasRelayPeers() bool {
+	return len(node.peerAddrs) > 0 || node.Fanout.Policy == FanoutTree
+}
+
+// unfinishedTargets returns every neighbor with the symbols of the chunks it did not announce it decoded
+func (node *Node) unfinishedTargets(packets []*wire.SymbolPacket) []relayTarget {
+	roots := make([]HashKey, len(packets))
+	for j, packet := range packets {
+		roots[j] = convertToFixedSize(packet.RootHash)
+	}
+	var targets []relayTarget
+	idx0 := rand.Intn(len(node.peerAddrs))
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	for i := range node.peerAddrs {
+		neighbor := (i + idx0) % len(node.peerAddrs)
+		addr := node.peerAddrs[neighbor]
+		if addr == nil {
+			continue
+		}
+		var unfinished []int
+		for j, packet := range packets {
+			if chunks := node.neighborChunks[roots[j]]; chunks == nil || !chunks.decoded[int(packet.ChunkID)][neighbor] {
+				unfinished = append(unfinished, j)
+			}
+		}
+		if len(unfinished) > 0 {
+			targets = append(targets, relayTarget{addr: addr, packets: unfinished, hop: -1})
+		}
+	}
+	return targets
+}
+
+// treeTargets returns the children of the node in the relay tree of every symbol
+func (node *Node) treeTargets(packets []*wire.SymbolPacket) []relayTarget {
+	var targets []relayTarget
+	index := make(map[treeChild]int) // child to index in targets
+	for j, packet := range packets {
+		for _, child := range node.treeChildren(peerIDOf(packet.Sender), packet.SymbolID, int(packet.Hop)) {
+			t, ok := index[child]
+			if !ok {
+				t = len(targets)
+				index[child] = t
+				targets = append(targets, relayTarget{addr: node.allAddrs[child.peer], hop: child.hop})
+			}
+			targets[t].packets = append(targets[t].packets, j)
+		}
+	}
+	return targets
+}
+
+// treeRing returns the indices in AllPeers of the peers of the relay trees of sender, every peer but the sender,
+// and the position of the node in the ring, -1 if it is not in it
+func (node *Node) treeRing(sender PeerID) ([]int, int) {
+	ring := make([]int, 0, len(node.allIDs))
+	self := -1
+	for i, id := range node.allIDs {
+		if id == sender {
+			continue
+		}
+		if id == node.selfID {
+			self = len(ring)
+		}
+		ring = append(ring, i)
+	}
+	return ring, self
+}
+
+// treeChildren returns the children of the node in the relay tree of a symbol of sender received with hop
+// remaining hops. The tree spans the ring of AllPeers without the sender, it is rooted at the peer of the ring
+// at symbolID modulo its size and its branching b is the smallest one such that b^Hop covers the ring. The peer
+// at offset o from the root is reached through the digits of o in base b: a node relays to the peers at the
+// offsets m*b^j from itself, 0 < m < b and j < hop, with j remaining hops, as long as they are within the ring
+func (node *Node) treeChildren(sender PeerID, symbolID uint32, hop int) []treeChild {
+	ring, self := node.treeRing(sender)
+	if self < 0 || hop < 1 || hop > node.Hop {
+		return nil
+	}
+	offset := (self - int(symbolID)%len(ring) + len(ring)) % len(ring)
+	b := treeBranching(len(ring), node.Hop)
+	strides := make([]int, hop)
+	strides[0] = 1
+	for j := 1; j < hop; j++ {
+		strides[j] = strides[j-1] * b
+	}
+	var children []treeChild
+	// the largest subtrees first, they take the longest to cover
+	for j := hop - 1; j >= 0; j-- {
+		for m := 1; m < b && offset+m*strides[j] < len(ring); m++ {
+			child := ring[(self+m*strides[j])%len(ring)]
+			if node.allAddrs[child] != nil {
+				children = append(children, treeChild{peer: child, hop: j})
+			}
+		}
+	}
+	return children
+}
+
+// sendAddrs returns the addresses the sender spreads the symbols of its broadcasts over, symbol n going to
+// the address at n modulo their number: its neighbors, or with FanoutTree the ring of its relay trees
+func (node *Node) sendAddrs() []net.Addr {
+	if node.Fanout.Policy != FanoutTree {
+		return node.peerAddrs
+	}
+	ring, _ := node.treeRing(node.selfID)
+	addrs := make([]net.Addr, len(ring))
+	for i, peer := range ring {
+		addrs[i] = node.allAddrs[peer]
+	}
+	return addrs
+}
+
+// treeBranching returns the smallest branching of a tree of the given depth with n nodes at least
+func treeBranching(n int, depth int) int {
+	for b := 2; ; b++ {
+		size := 1
+		for i := 0; i < depth && size < n; i++ {
+			size *= b
+		}
+		if size >= n {
+			return b
+		}
+	}
+}
+
+// announce queues the signed acknowledgement of a decoded chunk to be sent to the neighbors
+func (node *Node) announce(ack []byte) {
+	select {
+	case node.pipeline.notices <- ack:
+	default:
+		log.Printf("decoded chunk not announced, notice queue is full")
+	}
+}
+
+// sendNotice sends the acknowledgement of a decoded chunk to every neighbor
+func (node *Node) sendNotice(ctx context.Context, conn *udpbatch.Conn, ack []byte) {
+	batch := make([]udpbatch.Message, 0, len(node.peerAddrs))
+	for _, addr := range node.peerAddrs {
+		if addr != nil {
+			batch = append(batch, udpbatch.Message{Buf: ack, Addr: addr})
+		}
+	}
+	if !node.throttleUpload(ctx, len(batch)*len(ack)) {
+		return
+	}
+	if n, err := conn.WriteBatch(batch); err != nil {
+		log.Printf("announce decoded chunk failed with %v of %v notices written: %v", n, len(batch), err)
+	}
+}
+
+// handleNotice records the chunk a neighbor announced it decoded. Only the chunks of the messages in the
+// cache are recorded, so that a neighbor cannot fill the memory of the node with announcements
+func (node *Node) handleNotice(ack *wire.AckPacket) {
+	neighbor, ok := node.neighbors[peerIDOf(ack.Peer)]
+	if !ok || !node.verifyAck(ack) {
+		return
+	}
+	root := convertToFixedSize(ack.RootHash)
+	chunkID := int(ack.ChunkID)
+	var sessions []*RaptorQImpl
+	node.mux.Lock()
+	for key, raptorq := range node.Cache {
+		if key.Root == root {
+			sessions = append(sessions, raptorq)
+		}
+	}
+	node.mux.Unlock()
+	known := false
+	for _, raptorq := range sessions {
+		raptorq.mux.Lock()
+		known = known || chunkID < raptorq.numChunks
+		raptorq.mux.Unlock()
+	}
+	if !known {
+		return
+	}
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	chunks := node.neighborChunks[root]
+	if chunks == nil {
+		chunks = &neighborChunks{decoded: make(map[int]map[int]bool)}
+		node.neighborChunks[root] = chunks
+	}
+	if chunks.decoded[chunkID] == nil {
+		chunks.decoded[chunkID] = make(map[int]bool)
+	}
+	chunks.decoded[chunkID][neighbor] = true
+	chunks.updated = time.Now().UnixNano()
+}
+
+// clearNeighborChunks forgets the announcements older than blacklistTime, caller must hold node.mux
+func (node *Node) clearNeighborChunks(currentTime int64) {
+	for root, chunks := range node.neighborChunks {
+		if currentTime-chunks.updated > int64(blacklistTime*time.Second) {
+			delete(node.neighborChunks, root)
+		}
+	}
+}
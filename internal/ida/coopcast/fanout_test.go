@@ -0,0 +1,333 @@
+package coopcast
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// newFanoutNode returns the first node of a cluster of n nodes relaying with policy
+func newFanoutNode(t *testing.T, n int, opts FanoutOptions) (*testCluster, *Node) {
+	c := newTestCluster(t, n, func(i int, o *Options) { o.Fanout = opts })
+	return c, c.nodes[0]
+}
+
+// testPackets returns symbols of the chunks of the message root
+func testPackets(root byte, chunkIDs ...uint32) []*wire.SymbolPacket {
+	packets := make([]*wire.SymbolPacket, len(chunkIDs))
+	for i, chunkID := range chunkIDs {
+		packets[i] = &wire.SymbolPacket{RootHash: bytes.Repeat([]byte{root}, wire.HashSize), ChunkID: chunkID, Hop: 1}
+	}
+	return packets
+}
+
+// targetPackets returns the symbols relayed to every address
+func targetPackets(t *testing.T, targets []relayTarget) map[string][]int {
+	packets := make(map[string][]int)
+	for _, target := range targets {
+		addr := target.addr.String()
+		if _, ok := packets[addr]; ok {
+			t.Errorf("symbols relayed twice to %v", addr)
+		}
+		if target.hop != -1 {
+			t.Errorf("symbols relayed to %v with %v hops, want the received ones minus one", addr, target.hop)
+		}
+		packets[addr] = target.packets
+	}
+	return packets
+}
+
+// TestFanoutAll checks that every neighbor gets every symbol
+func TestFanoutAll(t *testing.T) {
+	c, node := newFanoutNode(t, 5, FanoutOptions{})
+	packets := targetPackets(t, node.fanout(testPackets(1, 0, 1)))
+	if len(packets) != 4 {
+		t.Errorf("symbols relayed to %v peers, want the 4 neighbors", len(packets))
+	}
+	for i := 1; i < len(c.peers); i++ {
+		if got := packets[node.peerAddrs[i-1].String()]; !reflect.DeepEqual(got, []int{0, 1}) {
+			t.Errorf("neighbor %v gets symbols %v, want [0 1]", i, got)
+		}
+	}
+}
+
+// TestFanoutRandom checks that the random policy picks Fanout distinct neighbors, all of them if there are fewer
+func TestFanoutRandom(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodes   int
+		fanout  int
+		targets int
+	}{
+		{"fewer than the neighbors", 6, 2, 2},
+		{"as many as the neighbors", 5, 4, 4},
+		{"more than the neighbors", 3, 4, 2},
+		{"default", 8, 0, defaultFanout},
+		{"no neighbor", 1, 4, 0},
+	}
+	for _, test := range tests {
+		_, node := newFanoutNode(t, test.nodes, FanoutOptions{Policy: FanoutRandom, Fanout: test.fanout})
+		self := node.allAddrs[0].String()
+		neighbors := make(map[string]bool)
+		for _, addr := range node.peerAddrs {
+			neighbors[addr.String()] = true
+		}
+		for i := 0; i < 20; i++ {
+			packets := targetPackets(t, node.fanout(testPackets(1, 0, 1, 2)))
+			if len(packets) != test.targets {
+				t.Errorf("%v: symbols relayed to %v peers, want %v", test.name, len(packets), test.targets)
+			}
+			for addr, got := range packets {
+				if addr == self || !neighbors[addr] {
+					t.Errorf("%v: symbols relayed to %v which is not a neighbor", test.name, addr)
+				}
+				if !reflect.DeepEqual(got, []int{0, 1, 2}) {
+					t.Errorf("%v: %v gets symbols %v, want all of them", test.name, addr, got)
+				}
+			}
+		}
+	}
+}
+
+// TestFanoutUnfinished checks that a neighbor only gets the symbols of the chunks it did not announce it decoded
+func TestFanoutUnfinished(t *testing.T) {
+	c, node := newFanoutNode(t, 5, FanoutOptions{Policy: FanoutUnfinished})
+	root := convertToFixedSize(bytes.Repeat([]byte{1}, wire.HashSize))
+	// the neighbors 1, 2 and 3 in PeerList decoded chunk 0 of the message, 2 and 3 its chunk 1 too
+	node.neighborChunks[root] = &neighborChunks{decoded: map[int]map[int]bool{
+		0: {1: true, 2: true, 3: true},
+		1: {2: true, 3: true},
+	}}
+	tests := []struct {
+		name    string
+		packets []*wire.SymbolPacket
+		want    [][]int // symbols of every neighbor, in PeerList order
+	}{
+		{"decoded chunks", testPackets(1, 0, 1), [][]int{{0, 1}, {1}, nil, nil}},
+		{"other message", testPackets(2, 0, 1), [][]int{{0, 1}, {0, 1}, {0, 1}, {0, 1}}},
+		{"chunk nobody announced", testPackets(1, 2), [][]int{{0}, {0}, {0}, {0}}},
+		{"mixed", append(testPackets(1, 0), testPackets(2, 0)...), [][]int{{0, 1}, {1}, {1}, {1}}},
+	}
+	for _, test := range tests {
+		packets := targetPackets(t, node.fanout(test.packets))
+		for i, want := range test.want {
+			addr := node.peerAddrs[i].String()
+			if got, ok := packets[addr]; !reflect.DeepEqual(got, want) || ok != (want != nil) {
+				t.Errorf("%v: neighbor %v gets symbols %v, want %v", test.name, c.peers[i+1].Sid, got, want)
+			}
+		}
+	}
+}
+
+// TestFanoutNoNeighbor checks that a node without neighbors only relays along the relay tree
+func TestFanoutNoNeighbor(t *testing.T) {
+	for _, policy := range []FanoutPolicy{FanoutAll, FanoutRandom, FanoutUnfinished, FanoutTree} {
+		c := newTestCluster(t, 5, func(i int, opts *Options) {
+			opts.PeerList = nil
+			opts.Fanout.Policy = policy
+		})
+		node := c.nodes[0]
+		packets := testPackets(1, 0)
+		packets[0].Sender = c.keys[4].Public().(ed25519.PublicKey)
+		tree := policy == FanoutTree
+		if targets := node.fanout(packets); (len(targets) > 0) != tree {
+			t.Errorf("%v: symbols relayed to %v", policy, targets)
+		}
+		node.relay(packets[0], 0)
+		if queued := node.PipelineStats().RelayQueue; (queued > 0) != tree {
+			t.Errorf("%v: %v symbols queued to relay", policy, queued)
+		}
+	}
+}
+
+// TestHandleNotice checks which announcements of decoded chunks are recorded
+func TestHandleNotice(t *testing.T) {
+	c := newTestCluster(t, 3, func(i int, opts *Options) {
+		if i == 0 {
+			// node 2 is a peer but not a neighbor
+			opts.PeerList = opts.PeerList[:1]
+		}
+	})
+	node := c.nodes[0]
+	rootHash := bytes.Repeat([]byte{1}, wire.HashSize)
+	root := convertToFixedSize(rootHash)
+	raptorq := node.initRaptorQIfNotExist(SHA256, SessionKey{Sender: PeerID{9}, Root: root})
+	raptorq.numChunks = 2
+	notice := func(rootHash []byte, chunkID uint32, peer int, signer int) *wire.AckPacket {
+		var ack wire.AckPacket
+		data := testAck(t, rootHash, chunkID, c.keys[peer].Public().(ed25519.PublicKey), c.keys[signer])
+		if err := ack.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		return &ack
+	}
+	tests := []struct {
+		name    string
+		ack     *wire.AckPacket
+		chunkID int
+		decoded bool
+	}{
+		{"neighbor", notice(rootHash, 1, 1, 1), 1, true},
+		{"chunk out of the message", notice(rootHash, 2, 1, 1), 2, false},
+		{"message not in the cache", notice(bytes.Repeat([]byte{2}, wire.HashSize), 0, 1, 1), 0, false},
+		{"not a neighbor", notice(rootHash, 0, 2, 2), 0, false},
+		{"signed by another peer", notice(rootHash, 0, 1, 2), 0, false},
+	}
+	for _, test := range tests {
+		node.handleNotice(test.ack)
+		node.mux.Lock()
+		chunks := node.neighborChunks[convertToFixedSize(test.ack.RootHash)]
+		decoded := chunks != nil && chunks.decoded[test.chunkID][0]
+		node.mux.Unlock()
+		if decoded != test.decoded {
+			t.Errorf("%v: chunk %v recorded as decoded %v, want %v", test.name, test.chunkID, decoded, test.decoded)
+		}
+	}
+}
+
+// treeNodes returns n peers relaying along the trees of depth hop, with the IDs 1 to n
+func treeNodes(n int, hop int) []*Node {
+	ids := make([]PeerID, n)
+	addrs := make([]net.Addr, n)
+	for i := range ids {
+		ids[i] = PeerID{byte(i + 1)}
+		addrs[i] = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 30000 + i}
+	}
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		nodes[i] = &Node{Hop: hop, selfID: ids[i], allIDs: ids, allAddrs: addrs}
+	}
+	return nodes
+}
+
+// walkTree relays a symbol of sender from the root of its tree and returns how many times every peer got it
+func walkTree(nodes []*Node, sender int, symbolID uint32) []int {
+	reached := make([]int, len(nodes))
+	ring, _ := nodes[sender].treeRing(nodes[sender].selfID)
+	if len(ring) == 0 {
+		return reached
+	}
+	queue := []treeChild{{peer: ring[int(symbolID)%len(ring)], hop: nodes[sender].Hop}}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		reached[next.peer]++
+		queue = append(queue, nodes[next.peer].treeChildren(nodes[sender].selfID, symbolID, next.hop)...)
+	}
+	return reached
+}
+
+// TestTreeChildren checks that the relay tree reaches every peer but the sender exactly once, whatever the
+// number of peers, the depth of the tree and its root
+func TestTreeChildren(t *testing.T) {
+	for n := 1; n <= 64; n++ {
+		for hop := 1; hop <= 3; hop++ {
+			nodes := treeNodes(n, hop)
+			for _, sender := range []int{0, n / 2, n - 1} {
+				for symbolID := uint32(0); symbolID <= uint32(n); symbolID++ {
+					for i, reached := range walkTree(nodes, sender, symbolID) {
+						if i == sender && reached != 0 || i != sender && reached != 1 {
+							t.Errorf("%v peers, hop %v, sender %v, symbol %v: peer %v reached %v times", n, hop, sender, symbolID, i, reached)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestTreeChildrenExclusions checks that the relay tree never includes the sender nor the node itself,
+// and that a node outside the tree or out of hops relays to nobody
+func TestTreeChildrenExclusions(t *testing.T) {
+	nodes := treeNodes(5, 2)
+	sender := nodes[0].selfID
+	for i, node := range nodes[1:] {
+		for _, child := range node.treeChildren(sender, uint32(i), 2) {
+			if child.peer == 0 || child.peer == i+1 {
+				t.Errorf("peer %v relays to peer %v", i+1, child.peer)
+			}
+		}
+	}
+	tests := []struct {
+		name   string
+		node   *Node
+		sender PeerID
+		hop    int
+	}{
+		{"own symbol", nodes[1], nodes[1].selfID, 2},
+		{"no hop left", nodes[1], sender, 0},
+		{"more hops than the tree", nodes[1], sender, 3},
+		{"not in AllPeers", &Node{Hop: 2, selfID: PeerID{99}, allIDs: nodes[0].allIDs, allAddrs: nodes[0].allAddrs}, sender, 2},
+	}
+	for _, test := range tests {
+		if children := test.node.treeChildren(test.sender, 0, test.hop); len(children) != 0 {
+			t.Errorf("%v: relays to %v", test.name, children)
+		}
+	}
+	// a peer without address is skipped
+	unresolved := treeNodes(5, 1)
+	for _, node := range unresolved {
+		node.allAddrs = append([]net.Addr(nil), node.allAddrs...)
+		node.allAddrs[2] = nil
+	}
+	for _, child := range unresolved[1].treeChildren(sender, 0, 1) {
+		if child.peer == 2 {
+			t.Errorf("relays to a peer without address")
+		}
+	}
+}
+
+// TestTreeTargets checks that the symbols of a batch are grouped by child with the hops of their subtree
+func TestTreeTargets(t *testing.T) {
+	c, node := newFanoutNode(t, 5, FanoutOptions{Policy: FanoutTree})
+	node.Hop = 2
+	packets := testPackets(1, 0, 1, 0)
+	for _, packet := range packets {
+		packet.Sender = c.keys[4].Public().(ed25519.PublicKey)
+		packet.Hop = 2
+	}
+	packets[2].SymbolID = 1
+	// node 0 is the root of the tree of symbol 0 over the ring 0 1 2 3: it relays to 2 with one hop left,
+	// then to 1 with none. It is a leaf of the tree of symbol 1, rooted at 1
+	targets := node.fanout(packets)
+	want := []relayTarget{
+		{addr: node.allAddrs[2], packets: []int{0, 1}, hop: 1},
+		{addr: node.allAddrs[1], packets: []int{0, 1}, hop: 0},
+	}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("targets %v, want %v", targets, want)
+	}
+}
+
+// TestSendAddrs checks that the sender spreads its symbols over its neighbors, or over the roots of its trees
+func TestSendAddrs(t *testing.T) {
+	c := newTestCluster(t, 4, func(i int, opts *Options) {
+		if i == 1 {
+			opts.Fanout.Policy = FanoutTree
+			opts.PeerList = opts.PeerList[:1]
+		}
+	})
+	if addrs := c.nodes[0].sendAddrs(); !reflect.DeepEqual(addrs, c.nodes[0].peerAddrs) {
+		t.Errorf("symbols sent to %v, want the neighbors %v", addrs, c.nodes[0].peerAddrs)
+	}
+	tree := c.nodes[1]
+	want := []net.Addr{tree.allAddrs[0], tree.allAddrs[2], tree.allAddrs[3]}
+	if addrs := tree.sendAddrs(); !reflect.DeepEqual(addrs, want) {
+		t.Errorf("symbols sent to %v, want every other peer %v", addrs, want)
+	}
+}
+
+// TestParseFanoutPolicy checks that every policy parses back from its name
+func TestParseFanoutPolicy(t *testing.T) {
+	for _, policy := range []FanoutPolicy{FanoutAll, FanoutRandom, FanoutUnfinished, FanoutTree} {
+		if parsed, err := ParseFanoutPolicy(policy.String()); err != nil || parsed != policy {
+			t.Errorf("ParseFanoutPolicy(%q) = %v, %v, want %v", policy.String(), parsed, err, policy)
+		}
+	}
+	if _, err := ParseFanoutPolicy("some"); err == nil {
+		t.Errorf("ParseFanoutPolicy(some) succeeded")
+	}
+}
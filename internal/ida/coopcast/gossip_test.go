@@ -0,0 +1,64 @@
+package coopcast
+
+import (
+	"crypto/ed25519"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// TestGossipAuthenticates feeds datagrams to a running node and checks how the receive pipeline accounts them
+func TestGossipAuthenticates(t *testing.T) {
+	c := newTestCluster(t, 2, nil)
+	c.start(t)
+	defer c.close()
+	node := c.nodes[1]
+
+	_, stranger, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, _ := testSymbol(t, c.keys[0]).MarshalBinary()
+	unknown, _ := testSymbol(t, stranger).MarshalBinary()
+	forged := testSymbol(t, c.keys[0])
+	forged.Signature[0] ^= 0xff
+	badSignature, _ := forged.MarshalBinary()
+
+	// the datagrams failing the authentication blacklist their source
+	tests := []struct {
+		name   string
+		source int
+		data   []byte
+		stats  AuthStats
+		black  uint64
+	}{
+		{"malformed", 40000, []byte("not a packet"), AuthStats{Malformed: 1}, 0},
+		{"valid", 40000, valid, AuthStats{Malformed: 1, Verified: 1}, 0},
+		{"unknown sender", 40001, unknown, AuthStats{Malformed: 1, Verified: 1, UnknownSender: 1}, 0},
+		{"valid from unknown sender relay", 40001, valid, AuthStats{Malformed: 1, Verified: 1, UnknownSender: 1}, 1},
+		{"bad signature", 40002, badSignature, AuthStats{Malformed: 1, Verified: 1, UnknownSender: 1, BadSignature: 1}, 1},
+		{"valid from forger", 40002, valid, AuthStats{Malformed: 1, Verified: 1, UnknownSender: 1, BadSignature: 1}, 2},
+		{"valid again", 40000, valid, AuthStats{Malformed: 1, Verified: 2, UnknownSender: 1, BadSignature: 1}, 2},
+	}
+	to, err := c.network.ResolveAddr(c.peers[1].IP + ":" + c.peers[1].UDPPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := make(map[int]net.PacketConn)
+	for _, test := range tests {
+		pc := sources[test.source]
+		if pc == nil {
+			if pc, err = c.network.ListenPacket(":" + strconv.Itoa(test.source)); err != nil {
+				t.Fatal(err)
+			}
+			defer pc.Close()
+			sources[test.source] = pc
+		}
+		if _, err := pc.WriteTo(test.data, to); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, test.name, func() bool {
+			return node.AuthStats() == test.stats && node.DeliveryStats().BlacklistedPackets == test.black
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package coopcast
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errors reported by BroadCastHandle.Wait
+var (
+	ErrBroadCastTimeout  = errors.New("broadcast timed out before enough peers decoded the message")
+	ErrBroadCastCanceled = errors.New("broadcast canceled")
+	ErrUploadCapExceeded = errors.New("broadcast used the upload budget of its message")
+)
+
+// broadCastHandle is the BroadCastHandle returned by Node.BroadCast
+type broadCastHandle struct {
+	node    *Node
+	raptorq *RaptorQImpl
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	done    chan struct{}
+
+	mux        sync.Mutex
+	cancels    map[int]context.CancelFunc // chunks being broadcast
+	err        error
+	finishTime int64
+}
+
+func newBroadCastHandle(node *Node, raptorq *RaptorQImpl, timeout time.Duration) *broadCastHandle {
+	ctx, cancel := context.WithCancel(node.context())
+	handle := broadCastHandle{node: node, raptorq: raptorq, ctx: ctx, cancel: cancel, timeout: timeout, done: make(chan struct{})}
+	handle.cancels = make(map[int]context.CancelFunc)
+	return &handle
+}
+
+func (handle *broadCastHandle) chunkContext(chunkID int) context.Context {
+	ctx, cancel := context.WithCancel(handle.ctx)
+	handle.mux.Lock()
+	handle.cancels[chunkID] = cancel
+	handle.mux.Unlock()
+	return ctx
+}
+
+// cancelChunk stops the broadcast of a chunk decoded by enough peers
+func (handle *broadCastHandle) cancelChunk(chunkID int) {
+	handle.mux.Lock()
+	cancel := handle.cancels[chunkID]
+	delete(handle.cancels, chunkID)
+	handle.mux.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// dispatch starts the broadcast of the chunks in order, at most window chunks are broadcast at the same time
+// and the next one starts when enough peers decoded one of them
+func (handle *broadCastHandle) dispatch(pc net.PacketConn, window int) {
+	node := handle.node
+	slots := make(chan struct{}, window)
+	for z := 0; z < handle.raptorq.numChunks; z++ {
+		select {
+		case <-handle.ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+		ctx, chunkID := handle.chunkContext(z), z
+		started := node.goroutine(func() {
+			defer func() { <-slots }()
+			if err := node.broadCastEncodedSymbol(ctx, handle.raptorq, pc, chunkID); err != nil {
+				handle.finish(err)
+			}
+		})
+		if !started {
+			return
+		}
+	}
+}
+
+// Wait blocks until the broadcast finished and returns the reason it stopped
+func (handle *broadCastHandle) Wait() error {
+	<-handle.done
+	handle.mux.Lock()
+	defer handle.mux.Unlock()
+	return handle.err
+}
+
+// Cancel stops the broadcast of every chunk
+func (handle *broadCastHandle) Cancel() {
+	handle.cancel()
+}
+
+// Stats returns the confirmation time of finished chunks and the overall elapsed time
+func (handle *broadCastHandle) Stats() BroadCastStats {
+	raptorq := handle.raptorq
+	stats := BroadCastStats{NumChunks: raptorq.numChunks, FinishedChunks: make(map[int]time.Duration), Threshold: raptorq.threshold, Speed: raptorq.pacer.currentSpeed()}
+	raptorq.mux.Lock()
+	for z, delta := range raptorq.stats {
+		stats.FinishedChunks[z] = time.Duration(delta * float64(time.Millisecond))
+	}
+	raptorq.mux.Unlock()
+	handle.mux.Lock()
+	end := handle.finishTime
+	handle.mux.Unlock()
+	if end == 0 {
+		end = time.Now().UnixNano()
+	}
+	stats.Elapsed = time.Duration(end - raptorq.initTime)
+	return stats
+}
+
+// finish stops the broadcast with err, the first call only counts
+func (handle *broadCastHandle) finish(err error) {
+	handle.mux.Lock()
+	if handle.finishTime != 0 {
+		handle.mux.Unlock()
+		return
+	}
+	handle.err = err
+	handle.finishTime = time.Now().UnixNano()
+	handle.mux.Unlock()
+	handle.cancel()
+	if err == ErrUploadCapExceeded {
+		atomic.AddUint64(&handle.node.uploadStats.CappedMessages, 1)
+	}
+	hashkey := convertToFixedSize(handle.raptorq.rootHash)
+	handle.node.mux.Lock()
+	if handle.node.pacers[hashkey] == handle.raptorq.pacer {
+		delete(handle.node.pacers, hashkey)
+	}
+	handle.node.mux.Unlock()
+	close(handle.done)
+}
+
+// monitor controls when to stop sender from continuing broadcast
+func (handle *broadCastHandle) monitor() {
+	node := handle.node
+	raptorq := handle.raptorq
+	hashkey := convertToFixedSize(raptorq.rootHash)
+	canceled := make(map[int]bool)
+	timeout := time.After(handle.timeout)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-handle.ctx.Done():
+			handle.finish(ErrBroadCastCanceled)
+			return
+		case <-timeout:
+			handle.finish(ErrBroadCastTimeout)
+			return
+		case <-ticker.C:
+		}
+		for z := 0; z < raptorq.numChunks; z++ {
+			if canceled[z] {
+				continue
+			}
+			if node.decodedPeers(hashkey, z) >= raptorq.threshold {
+				delta := float64(time.Now().UnixNano()-raptorq.initTime) / 1000000
+				raptorq.mux.Lock()
+				raptorq.stats[z] = delta
+				raptorq.mux.Unlock()
+				handle.cancelChunk(z)
+				canceled[z] = true
+				log.Printf("***** chunkID %v canceled", z)
+			}
+		}
+		if len(canceled) >= raptorq.numChunks {
+			log.Printf("t0/t1/base/t2/hop: %v ms, %v ms, %v, %v ms, %v", node.InitialDelayTime, node.MaxDelayTime, node.ExpBase, node.RelayTime, node.Hop)
+			for z, delta := range handle.Stats().FinishedChunks {
+				log.Printf("block %v broadcast finished with time elapse = %v", z, delta)
+			}
+			log.Printf("total broadcast time: %v ms", float64(time.Now().UnixNano()-raptorq.initTime)/1000000)
+			handle.finish(nil)
+			return
+		}
+	}
+}
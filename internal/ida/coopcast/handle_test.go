@@ -0,0 +1,98 @@
+package coopcast
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBroadCastWithoutPeers checks that a node without neighbors refuses to broadcast
+func TestBroadCastWithoutPeers(t *testing.T) {
+	node := NewNode(Options{SelfPeer: Peer{IP: "127.0.0.1", TCPPort: "20000", UDPPort: "30000"}})
+	if _, err := node.BroadCast([]byte("message"), nil); err != errNoPeers {
+		t.Errorf("BroadCast() = %v, want %v", err, errNoPeers)
+	}
+}
+
+// TestBroadCastCancel checks that a canceled broadcast stops with ErrBroadCastCanceled and reports its chunks
+func TestBroadCastCancel(t *testing.T) {
+	c := newTestCluster(t, 2, nil)
+	pc, err := c.listen(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	// the other node is not started, its acknowledgements never arrive
+	h, err := c.nodes[0].BroadCast(make([]byte, DefaultEncodingParams().ChunkSize/2), pc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	h.Cancel()
+	if err := h.Wait(); err != ErrBroadCastCanceled {
+		t.Errorf("Wait() = %v, want %v", err, ErrBroadCastCanceled)
+	}
+	stats := h.Stats()
+	if stats.NumChunks != 1 || len(stats.FinishedChunks) != 0 {
+		t.Errorf("%v of %v chunks finished, want 0 of 1", len(stats.FinishedChunks), stats.NumChunks)
+	}
+	if elapsed := h.Stats().Elapsed; elapsed != stats.Elapsed {
+		t.Errorf("elapsed time grew from %v to %v after the broadcast stopped", stats.Elapsed, elapsed)
+	}
+}
+
+// countingConditioner counts the datagrams sent from an address
+type countingConditioner struct {
+	src  string
+	sent int64
+}
+
+func (c *countingConditioner) Datagram(src, dst string, size int) (time.Duration, bool) {
+	if src == c.src {
+		atomic.AddInt64(&c.sent, 1)
+	}
+	return 0, false
+}
+
+// TestBroadCastStopsAfterThreshold checks that a broadcast completes once enough peers decoded every chunk,
+// although a peer never answers, and that the sender stops sending symbols then
+func TestBroadCastStopsAfterThreshold(t *testing.T) {
+	const numNodes = 6
+	c := newTestCluster(t, numNodes, nil)
+	counter := &countingConditioner{src: ":" + c.peers[0].UDPPort}
+	c.network.Conditioner = counter
+	// the last node is down
+	down := c.nodes[numNodes-1]
+	c.nodes = c.nodes[:numNodes-1]
+	c.start(t)
+	defer c.close()
+	down.Close()
+
+	msg := make([]byte, 200<<10)
+	rand.Read(msg)
+	encoding := DefaultEncodingParams()
+	encoding.ChunkSize = 64 << 10
+	h, err := c.nodes[0].BroadCastWith(msg, c.conns[0], BroadCastOptions{Encoding: encoding})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	stats := h.Stats()
+	if want := int(threshold * float32(len(c.peers))); stats.Threshold != want {
+		t.Errorf("threshold %v, want %v", stats.Threshold, want)
+	}
+	if stats.NumChunks < 2 || len(stats.FinishedChunks) != stats.NumChunks {
+		t.Errorf("%v of %v chunks finished", len(stats.FinishedChunks), stats.NumChunks)
+	}
+
+	// the symbols already handed to the network are delivered at once, none is sent after a short grace period
+	time.Sleep(100 * time.Millisecond)
+	sent := atomic.LoadInt64(&counter.sent)
+	time.Sleep(500 * time.Millisecond)
+	if after := atomic.LoadInt64(&counter.sent); after != sent {
+		t.Errorf("sender kept sending %v datagrams after the broadcast completed", after-sent)
+	}
+}
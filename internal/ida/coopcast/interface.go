@@ -2,24 +2,27 @@ package coopcast
 
 import (
 	"context"
-	"crypto/sha1"
+	"crypto/ed25519"
 	libraptorq "github.com/harmony-one/go-raptorq/pkg/raptorq"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+	"github.com/harmony-one/libunison/internal/ida/transport"
+	"io"
 	"net"
 	"sync"
 	"time"
 )
 
 const (
-	metaReceived         byte          = 0
-	pubKeySize           int           = 20
-	stopBroadCastTime    time.Duration = 100 // unit is second
-	cacheClearInterval   time.Duration = 250 // clear cache every xx seconds
-	enforceClearInterval int64         = 300 // clear old cache eventually
-	udpCacheSize         int           = 2 * 1024
-	symbolSize           int           = 1200 // must be multiple of Al(=4) required by RFC6330
-	normalChunkSize      int           = 100 * symbolSize
-
-	hashSize  int     = sha1.Size
+	pubKeySize           int           = ed25519.PublicKeySize
+	stopBroadCastTime    time.Duration = 100       // unit is second
+	responseReadTimeout  time.Duration = 10        // unit is second
+	cacheClearInterval   time.Duration = 250       // clear cache every xx seconds
+	enforceClearInterval int64         = 300       // clear old cache eventually
+	udpCacheSize         int           = 64 * 1024 // largest UDP datagram, symbols of jumbo frames included
+	blacklistTime        time.Duration = 300       // unit is second
+	defaultWindow        int           = 8         // chunks broadcast concurrently
+
+	hashSize  int     = wire.HashSize
 	threshold float32 = 0.8 // threshold rate of number of neighors decode message successfully
 )
 
@@ -28,30 +31,127 @@ type Peer struct {
 	IP      string
 	TCPPort string
 	UDPPort string
-	PubKey  string
-	Sid     int
+	PubKey  string // hex encoded ed25519 public key
+	Sid     int    // local index of the peer in the config files, only used for logging
 }
 
 // HashKey is the array of fixed size can be used as key in golang dictionary
 type HashKey [hashSize]byte
 
+// PeerID identifies a peer by its ed25519 public key, it is carried in symbol and ack packets
+type PeerID [pubKeySize]byte
+
+// SessionKey identifies a message being received, the same root hash signed by different senders makes different sessions
+type SessionKey struct {
+	Sender PeerID
+	Root   HashKey
+}
+
+// Options holds the parameters used to create a coopcast node
+type Options struct {
+	SelfPeer         Peer
+	PeerList         []Peer
+	AllPeers         []Peer
+	PrivKey          ed25519.PrivateKey // signs the symbols broadcast by the node, matches SelfPeer.PubKey
+	InitialDelayTime float64            // sender delay parameter
+	MaxDelayTime     float64            // sender delay parameter
+	ExpBase          float64            // sender delay parameter
+	RelayTime        float64            // gossip delay parameter
+	Hop              int
+	HashType         HashType            // digest of the messages broadcast by the node, SHA256 if unset
+	Encoding         EncodingParams      // encoding of the messages broadcast by the node, DefaultEncodingParams if unset
+	CacheLimits      CacheLimits         // memory of the decoders of received messages, defaults of CacheLimits if unset
+	Pipeline         PipelineOptions     // queues and workers handling the received symbols, defaults of PipelineOptions if unset
+	Pacing           PacingOptions       // pacer of the symbols broadcast by the node, the exponential schedule without cap if unset
+	RelayPacing      PacingOptions       // pacer of the symbols relayed by the node, RelayTime between neighbors if unset
+	Upload           UploadLimits        // bytes of the symbols sent by the node, unlimited if unset
+	Fanout           FanoutOptions       // peers the received symbols are relayed to, every neighbor if unset
+	Transport        transport.Transport // network of the acknowledgements and of the relayed symbols, UDP and TCP if unset
+
+	AllowUnknownSenders bool // accept messages signed by keys which are not in AllPeers
+}
+
 // Node represents a node using coopcast to send and receive message
 type Node struct {
-	BroadCaster
-
-	SelfPeer           Peer
-	PeerList           []Peer
-	AllPeers           []Peer
-	InitialDelayTime   float64 // sender delay parameter
-	MaxDelayTime       float64 // sender delay parameter
-	ExpBase            float64 // sender delay parameter
-	RelayTime          float64 // gossip delay parameter
-	Hop                int
-	SenderCache        map[HashKey]bool
-	Cache              map[HashKey]*RaptorQImpl
-	PeerDecodedCounter map[HashKey]map[int]int
-
-	mux sync.Mutex // mutex protect the concurrent write to the map in node, but not protect the fields in RaptorQimpl
+	SelfPeer            Peer
+	PeerList            []Peer
+	AllPeers            []Peer
+	InitialDelayTime    float64 // sender delay parameter
+	MaxDelayTime        float64 // sender delay parameter
+	ExpBase             float64 // sender delay parameter
+	RelayTime           float64 // gossip delay parameter
+	Hop                 int
+	HashType            HashType
+	Encoding            EncodingParams
+	CacheLimits         CacheLimits
+	Pipeline            PipelineOptions
+	Pacing              PacingOptions
+	RelayPacing         PacingOptions
+	Upload              UploadLimits
+	Fanout              FanoutOptions
+	Transport           transport.Transport
+	AllowUnknownSenders bool
+	SenderCache         map[HashKey]bool
+	Cache               map[SessionKey]*RaptorQImpl
+	PeerDecoded         map[HashKey]map[int]map[PeerID]bool // verified peers which decoded the chunks of the messages sent by the node
+
+	privKey       ed25519.PrivateKey
+	selfID        PeerID
+	peers         map[PeerID]Peer // every peer in AllPeers indexed by public key
+	peerAddrs     []net.Addr      // datagram address of every peer in PeerList, nil if it cannot be resolved
+	neighbors     map[PeerID]int  // index in PeerList of every neighbor
+	allIDs        []PeerID        // public key of every peer in AllPeers, zero if it is invalid
+	allAddrs      []net.Addr      // datagram address of every peer in AllPeers, nil if it cannot be resolved
+	relayPacer    Pacer
+	authStats     AuthStats
+	handlers      []MessageHandler
+	chunkHandlers []ChunkHandler
+	opener        StreamOpener
+
+	errHandlers     []ErrorHandler
+	blacklist       map[string]int64     // relay address to blacklist expiry time, UnixNano time
+	rejected        map[SessionKey]int64 // corrupt objects to rejection time, UnixNano time
+	rejectedSenders map[PeerID]int64     // senders of corrupt objects to rejection time, UnixNano time
+	deliveryStats   DeliveryStats
+
+	memory       int64                // bytes charged to the decoders in Cache
+	senderMemory map[PeerID]int64     // bytes charged to the decoders of every sender
+	finished     map[SessionKey]int64 // decoded messages evicted from Cache to eviction time, UnixNano time
+	cacheStats   CacheStats
+
+	pacers         map[HashKey]*rateController // rate of the broadcasts in progress, fed by their acknowledgements
+	neighborChunks map[HashKey]*neighborChunks // chunks the neighbors announced they decoded
+	pipeline       *pipeline                   // queues of the receive pipeline
+	pipelineStats  PipelineStats
+	upload         *uploadBudget // upload rate of the node and traffic of every message
+	uploadStats    UploadStats
+	mux            sync.Mutex // mutex protect the concurrent write to the map in node, but not protect the fields in RaptorQimpl
+
+	ctx       context.Context // canceled when the node is closed or the context given to Start is done
+	cancel    context.CancelFunc
+	started   bool
+	closed    bool
+	wg        sync.WaitGroup // tracks every goroutine started by the node
+	lifecycle sync.Mutex     // protects started and closed
+}
+
+// sessionState is the lifecycle of a message being received, transitions happen under RaptorQImpl.mux
+type sessionState int
+
+const (
+	sessionReceiving sessionState = iota // symbols are decoded
+	sessionDelivered                     // every chunk is decoded and the message delivered, symbols are only relayed
+	sessionReleased                      // evicted or discarded before the message was delivered
+)
+
+// chunkState serializes the use of the decoder of a chunk, so that chunks of a message are decoded in parallel.
+// The lock of a chunk is taken after RaptorQImpl.mux when both are held
+type chunkState struct {
+	mux         sync.Mutex
+	symbols     symbolSet
+	closed      bool   // the decoder is released
+	received    uint32 // distinct symbols received, reported to the sender with the acknowledgement
+	maxSymbolID uint32
 }
 
 // RaptorQImpl represents raptorQ structure holding necessary information for encoding and decoding message
@@ -59,22 +159,98 @@ type RaptorQImpl struct {
 	Encoder map[int]libraptorq.Encoder
 	Decoder map[int]libraptorq.Decoder
 
-	senderID        int
-	rootHash        []byte
-	numChunks       int
-	chunkSize       int
-	threshold       int
-	receivedSymbols map[int]map[uint32]bool
-	numDecoded      int
-	initTime        int64 //instance initiate time
-	successTime     int64 //success decode time, UnixNano time
-	mux             sync.Mutex
-	stats           map[int]float64 // for benchmark purpose
+	sender      PeerID
+	hashType    HashType
+	rootHash    []byte
+	numChunks   int
+	chunkSize   int             // size of every chunk but the last one
+	params      EncodingParams  // sender side only
+	pacer       *rateController // shared by the chunks being broadcast, sender side only
+	source      io.ReaderAt     // message being broadcast, sender side only
+	size        int64           // length of the message, sender side only
+	open        StreamOpener    // opener registered when the message was first received, nil to reassemble it in memory
+	stream      io.WriterAt     // destination of the decoded chunks
+	written     int64           // bytes written to stream
+	threshold   int
+	chunks      map[int]*chunkState // received symbols of every chunk
+	chunkHashes map[int][]byte      // hash of every chunk advertised by the sender
+	otis        map[int]oti         // encoder OTI of every chunk advertised by the sender
+	chunkProofs map[int][][]byte    // merkle proof of every chunk hash, sender side only
+	numDecoded  int
+	initTime    int64 //instance initiate time
+	successTime int64 //success decode time, UnixNano time, accessed atomically
+	lastActive  int64 // last time a new symbol was received, UnixNano time, accessed atomically
+	memory      int64 // bytes charged to the decoders, protected by node.mux
+	state       sessionState
+	done        chan struct{}   // closed when the decoders are released
+	mux         sync.Mutex      // protects every field but the immutable identity of the message, memory and the atomic ones
+	stats       map[int]float64 // for benchmark purpose
+}
+
+// Message represents a reassembled object handed over to the application
+type Message struct {
+	Payload     []byte // nil if the message was written to the io.WriterAt of a StreamOpener
+	Size        int64
+	RootHash    []byte
+	Sender      PeerID
+	SenderID    int   // Sid of the sender in AllPeers, -1 if the sender is unknown
+	InitTime    int64 // first symbol received time, UnixNano time
+	SuccessTime int64 // success decode time, UnixNano time
+}
+
+// Elapsed returns the time spent between the first received symbol and the successful decode
+func (msg Message) Elapsed() time.Duration {
+	return time.Duration(msg.SuccessTime - msg.InitTime)
+}
+
+// MessageHandler is called for every message successfully decoded by a node
+type MessageHandler func(msg Message)
+
+// Chunk represents a decoded chunk of a message, verified against the root hash by its merkle proof
+type Chunk struct {
+	Data      []byte
+	ChunkID   int
+	NumChunks int
+	Offset    int64 // position of the chunk in the message
+	RootHash  []byte
+	Sender    PeerID
+	SenderID  int // Sid of the sender in AllPeers, -1 if the sender is unknown
+}
+
+// ChunkHandler is called for every chunk as soon as it is decoded, chunks of a message arrive in any order
+type ChunkHandler func(chunk Chunk)
+
+// ErrorHandler is called for every message a node failed to deliver
+type ErrorHandler func(err error)
+
+// BroadCastStats reports the progress of a broadcast on the sender side
+type BroadCastStats struct {
+	NumChunks      int
+	FinishedChunks map[int]time.Duration // time elapsed until the chunk is decoded by enough peers
+	Elapsed        time.Duration
+	Threshold      int     // acknowledgements after which a chunk is not broadcast anymore
+	Speed          float64 // speed of an adaptive pacer, 1 for the other pacers
+}
+
+// BroadCastHandle controls a broadcast started by BroadCaster
+type BroadCastHandle interface {
+	// Wait blocks until enough peers decoded every chunk, the broadcast timed out or it was canceled
+	Wait() error
+	// Cancel stops sending symbols for every chunk of the broadcast
+	Cancel()
+	// Stats returns a snapshot of the broadcast progress
+	Stats() BroadCastStats
 }
 
 // BroadCaster interface define the broadcast interface for coopcast for both receiver and sender sides
 type BroadCaster interface {
-	BroadCast(msg []byte, pc net.PacketConn) (context.CancelFunc, *RaptorQImpl)
-	StopBroadCast(cancel context.CancelFunc, raptorq *RaptorQImpl)
-	ListeningOnBroadCast(pc net.PacketConn)
+	BroadCast(msg []byte, pc net.PacketConn) (BroadCastHandle, error)
+	BroadCastWith(msg []byte, pc net.PacketConn, opts BroadCastOptions) (BroadCastHandle, error)
+	BroadCastReader(r io.ReaderAt, size int64, pc net.PacketConn, opts BroadCastOptions) (BroadCastHandle, error)
+	Start(ctx context.Context, pc net.PacketConn) error
+	ListeningOnBroadCast(ctx context.Context, pc net.PacketConn) error
+	OnMessage(handler MessageHandler)
+	OnChunk(handler ChunkHandler)
+	OnStream(open StreamOpener)
+	Close() error
 }
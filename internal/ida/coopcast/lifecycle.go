@@ -0,0 +1,134 @@
+package coopcast
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+var (
+	errNodeStarted = errors.New("node already started")
+	errNodeClosed  = errors.New("node already closed")
+)
+
+// Start launches the goroutines receiving, relaying and acknowledging messages.
+// They all exit once ctx is canceled or Close is called; pc stays owned by the caller.
+// pc is usually opened with node.Transport.ListenPacket, the acknowledgements are received on node.Transport.
+func (node *Node) Start(ctx context.Context, pc net.PacketConn) error {
+	node.lifecycle.Lock()
+	defer node.lifecycle.Unlock()
+	if node.closed {
+		return errNodeClosed
+	}
+	if node.started {
+		return errNodeStarted
+	}
+	addr := net.JoinHostPort("", node.SelfPeer.TCPPort)
+	ln, err := node.Transport.Listen(addr)
+	if err != nil {
+		log.Printf("cannot listening to the port %s", node.SelfPeer.TCPPort)
+		return err
+	}
+	log.Printf("server start listening on tcp port %s", node.SelfPeer.TCPPort)
+	node.started = true
+	parent := ctx
+	ctx = node.ctx
+
+	node.spawn(func() {
+		select {
+		case <-parent.Done():
+			node.cancel()
+		case <-ctx.Done():
+		}
+	})
+	node.spawn(func() { node.Gossip(ctx, pc) })
+	node.spawn(func() { node.clearCache(ctx) })
+	node.spawn(func() { node.acceptResponses(ctx, ln) })
+	node.spawn(func() {
+		<-ctx.Done()
+		ln.Close()
+		// unblock the pending ReadFrom in Gossip without closing the caller's connection
+		pc.SetReadDeadline(time.Now())
+	})
+	return nil
+}
+
+// ListeningOnBroadCast listens and handle message received until ctx is canceled or the node is closed
+func (node *Node) ListeningOnBroadCast(ctx context.Context, pc net.PacketConn) error {
+	err := node.Start(ctx, pc)
+	if err != nil {
+		return err
+	}
+	<-node.context().Done()
+	return node.Close()
+}
+
+// Close stops the node and returns once every goroutine started by the node has exited
+func (node *Node) Close() error {
+	node.lifecycle.Lock()
+	node.closed = true
+	node.lifecycle.Unlock()
+	node.cancel()
+	node.wg.Wait()
+	return nil
+}
+
+func (node *Node) acceptResponses(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if ctx.Err() != nil {
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("cannot accept connection")
+			return
+		}
+		clientinfo := conn.RemoteAddr().String()
+		log.Printf("accept connection from %s", clientinfo)
+		if !node.goroutine(func() { node.handleResponse(conn) }) {
+			conn.Close()
+		}
+	}
+}
+
+// context returns the context of the node, it is done once the node is closed
+func (node *Node) context() context.Context {
+	return node.ctx
+}
+
+// goroutine runs f in a goroutine tracked by Close, it returns false when the node is already closed
+func (node *Node) goroutine(f func()) bool {
+	node.lifecycle.Lock()
+	defer node.lifecycle.Unlock()
+	if node.closed {
+		return false
+	}
+	node.spawn(f)
+	return true
+}
+
+// spawn must be called with node.lifecycle held
+func (node *Node) spawn(f func()) {
+	node.wg.Add(1)
+	go func() {
+		defer node.wg.Done()
+		f()
+	}()
+}
+
+// sleepContext sleeps for d and returns false if ctx is done before
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
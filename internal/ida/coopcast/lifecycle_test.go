@@ -0,0 +1,48 @@
+package coopcast
+
+import (
+	"context"
+	"runtime"
+	"testing"
+)
+
+// TestLifecycle checks the errors of Start and that Close stops every goroutine of the node
+func TestLifecycle(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	c := newTestCluster(t, 1, nil)
+	c.start(t)
+	node := c.nodes[0]
+	if err := node.Start(context.Background(), c.conns[0]); err != errNodeStarted {
+		t.Errorf("second Start() = %v, want %v", err, errNodeStarted)
+	}
+	if err := node.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	if err := node.Close(); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+	if err := node.Start(context.Background(), c.conns[0]); err != errNodeClosed {
+		t.Errorf("Start() after Close() = %v, want %v", err, errNodeClosed)
+	}
+	c.close()
+	waitGoroutines(t, goroutines)
+}
+
+// TestListeningStopsWithContext checks that ListeningOnBroadCast returns once its context is canceled
+func TestListeningStopsWithContext(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	c := newTestCluster(t, 1, nil)
+	pc, err := c.listen(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.nodes[0].ListeningOnBroadCast(ctx, pc) }()
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("ListeningOnBroadCast() = %v", err)
+	}
+	waitGoroutines(t, goroutines)
+}
@@ -0,0 +1,66 @@
+package coopcast
+
+import (
+	"context"
+	"errors"
+	"github.com/harmony-one/libunison/internal/ida/transport"
+)
+
+var (
+	errNoPeers      = errors.New("node has no neighbor peers to broadcast to")
+	errEmptyMessage = errors.New("cannot broadcast an empty message")
+)
+
+// NewNode creates a coopcast node with initialized caches
+func NewNode(opts Options) *Node {
+	node := Node{
+		SelfPeer:            opts.SelfPeer,
+		PeerList:            opts.PeerList,
+		AllPeers:            opts.AllPeers,
+		InitialDelayTime:    opts.InitialDelayTime,
+		MaxDelayTime:        opts.MaxDelayTime,
+		ExpBase:             opts.ExpBase,
+		RelayTime:           opts.RelayTime,
+		Hop:                 opts.Hop,
+		HashType:            opts.HashType,
+		Encoding:            opts.Encoding.orDefault(),
+		CacheLimits:         opts.CacheLimits.orDefault(),
+		Pipeline:            opts.Pipeline.orDefault(),
+		Pacing:              opts.Pacing,
+		RelayPacing:         opts.RelayPacing,
+		Upload:              opts.Upload.orDefault(),
+		Fanout:              opts.Fanout.orDefault(),
+		Transport:           opts.Transport,
+		AllowUnknownSenders: opts.AllowUnknownSenders,
+		privKey:             opts.PrivKey,
+		SenderCache:         make(map[HashKey]bool),
+		Cache:               make(map[SessionKey]*RaptorQImpl),
+		PeerDecoded:         make(map[HashKey]map[int]map[PeerID]bool),
+		blacklist:           make(map[string]int64),
+		rejected:            make(map[SessionKey]int64),
+		rejectedSenders:     make(map[PeerID]int64),
+		senderMemory:        make(map[PeerID]int64),
+		finished:            make(map[SessionKey]int64),
+		pacers:              make(map[HashKey]*rateController),
+		neighborChunks:      make(map[HashKey]*neighborChunks),
+	}
+	if !node.HashType.Valid() {
+		node.HashType = SHA256
+	}
+	if node.Transport == nil {
+		node.Transport = transport.Net{}
+	}
+	if node.RelayPacing.Strategy == PaceDefault {
+		node.RelayPacing.Strategy = PaceConstant
+	}
+	// canceled by Close, so that the broadcasts started before Start are canceled too
+	node.ctx, node.cancel = context.WithCancel(context.Background())
+	node.relayPacer = node.RelayPacing.newPacer(milliseconds(node.RelayTime), milliseconds(node.MaxDelayTime), node.ExpBase, 0)
+	node.pipeline = newPipeline(node.Pipeline)
+	node.upload = newUploadBudget(node.Upload)
+	node.loadPeerKeys()
+	node.resolvePeers()
+	return &node
+}
+
+var _ BroadCaster = (*Node)(nil)
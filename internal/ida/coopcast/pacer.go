@@ -0,0 +1,203 @@
+package coopcast
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Pacer schedules the symbols sent by a node: the symbols of a chunk being broadcast, or the neighbors a batch of
+// relayed symbols is sent to one after the other. The pacer of a broadcast is shared by all its chunks and the pacer
+// of the relays by all the relay workers, so a Pacer must be safe for concurrent use.
+type Pacer interface {
+	// Next returns when the symbol n of a sequence is due, the symbol n-1 was due at prev. The sequence is the symbols
+	// of a chunk and k its number of source symbols for a broadcast, the neighbors of a relay and k is 0 for a relay
+	Next(prev time.Time, n int, k int) time.Time
+}
+
+// AckObserver is implemented by the pacers adapting to the acknowledgements of the broadcast they pace
+type AckObserver interface {
+	OnAck(sample AckSample)
+}
+
+// AckSample is what the acknowledgement of a chunk tells about the network
+type AckSample struct {
+	ChunkID int
+	Loss    float64       // fraction of the symbols up to the last one received the peer missed or got too late
+	RTT     time.Duration // time from the send of the last symbol received by the peer to the acknowledgement, 0 if unknown
+	Time    time.Time
+}
+
+// ConstantPacer sends the symbols every Interval
+type ConstantPacer struct {
+	Interval time.Duration
+}
+
+// Next returns prev plus Interval
+func (p ConstantPacer) Next(prev time.Time, n int, k int) time.Time {
+	return prev.Add(p.Interval)
+}
+
+// LinearPacer sends the first k symbols every Interval, then adds Step to the delay of every other symbol up to Max
+type LinearPacer struct {
+	Interval time.Duration
+	Step     time.Duration
+	Max      time.Duration // uncapped if unset
+}
+
+// Next returns prev plus the linearly growing delay of symbol n
+func (p LinearPacer) Next(prev time.Time, n int, k int) time.Time {
+	d := p.Interval + time.Duration(math.Max(float64(n-k), 0))*p.Step
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+	return prev.Add(d)
+}
+
+// ExponentialPacer sends the first k symbols every Interval, then multiplies the delay by Base for every other
+// symbol up to Max. It is the schedule of InitialDelayTime, MaxDelayTime and ExpBase
+type ExponentialPacer struct {
+	Interval time.Duration
+	Max      time.Duration // Interval if lower
+	Base     float64
+}
+
+// Next returns prev plus the exponentially growing delay of symbol n
+func (p ExponentialPacer) Next(prev time.Time, n int, k int) time.Time {
+	max := p.Max
+	if max < p.Interval {
+		max = p.Interval
+	}
+	d := float64(p.Interval) * math.Pow(p.Base, math.Max(float64(n-k), 0))
+	if d > float64(max) {
+		d = float64(max)
+	}
+	return prev.Add(time.Duration(d))
+}
+
+// gcra is a token bucket scheduling the reservations of its tokens, tat is the theoretical arrival time of the
+// tokens following the last reservation
+type gcra struct {
+	rate  float64 // tokens per second
+	burst float64 // tokens available at once
+	mux   sync.Mutex
+	tat   time.Time
+}
+
+// reserve returns when n tokens are available, not before at. A reservation larger than burst waits for the tokens
+// beyond burst
+func (g *gcra) reserve(at time.Time, n float64) time.Time {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	// tokens of the past are lost
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	due := at
+	if earliest := g.tat.Add(seconds((n - g.burst) / g.rate)); earliest.After(due) {
+		due = earliest
+	}
+	if due.After(g.tat) {
+		g.tat = due
+	}
+	g.tat = g.tat.Add(seconds(n / g.rate))
+	return due
+}
+
+// seconds converts a fractional number of seconds
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// tokenBucketPacer takes a token of its bucket per symbol
+type tokenBucketPacer struct {
+	bucket *gcra
+}
+
+// NewTokenBucketPacer returns a pacer sending rate symbols per second on average and burst symbols at once
+// whatever the sequence they belong to, rate must be positive
+func NewTokenBucketPacer(rate float64, burst int) Pacer {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucketPacer{bucket: &gcra{rate: rate, burst: float64(burst)}}
+}
+
+// Next reserves the token of the symbol
+func (p *tokenBucketPacer) Next(prev time.Time, n int, k int) time.Time {
+	return p.bucket.reserve(prev, 1)
+}
+
+// AdaptivePacer runs a schedule at a speed set by an AIMD controller fed by the acknowledgements: the speed grows by
+// Increase for every acknowledgement and is multiplied by Decrease, at most once per round trip, when the smoothed
+// loss rate reported by the peers is LossTarget above its lowest value or the smoothed round trip time is twice its
+// lowest value. Without acknowledgements, as for relays, it follows the schedule
+type AdaptivePacer struct {
+	schedule Pacer
+	opts     PacingOptions
+
+	mux          sync.Mutex
+	speed        float64
+	loss         float64 // smoothed loss rate, -1 before the first acknowledgement
+	minLoss      float64
+	minRTT       time.Duration // lowest smoothed round trip time
+	srtt         time.Duration
+	rttSamples   int
+	lastDecrease time.Time
+}
+
+// NewAdaptivePacer returns a pacer running schedule at the speed set by the Increase, Decrease, LossTarget,
+// MinSpeed and MaxSpeed of opts, see PacingOptions for their defaults
+func NewAdaptivePacer(schedule Pacer, opts PacingOptions) *AdaptivePacer {
+	return &AdaptivePacer{schedule: schedule, opts: opts.adaptiveOrDefault(0), speed: 1, loss: -1, minLoss: 1}
+}
+
+// Next returns prev plus the delay of the schedule divided by the speed
+func (p *AdaptivePacer) Next(prev time.Time, n int, k int) time.Time {
+	d := p.schedule.Next(prev, n, k).Sub(prev)
+	return prev.Add(time.Duration(float64(d) / p.Speed()))
+}
+
+// Speed returns the factor applied to the rate of the schedule
+func (p *AdaptivePacer) Speed() float64 {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.speed
+}
+
+// OnAck adapts the speed to the reception a peer reported when it decoded a chunk
+func (p *AdaptivePacer) OnAck(sample AckSample) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	opts := p.opts
+	if p.loss < 0 {
+		p.loss = sample.Loss
+	} else {
+		p.loss = (7*p.loss + sample.Loss) / 8
+	}
+	p.minLoss = math.Min(p.minLoss, p.loss)
+	congested := p.loss-p.minLoss > opts.LossTarget
+	if sample.RTT > 0 {
+		if p.srtt == 0 {
+			p.srtt = sample.RTT
+		} else {
+			p.srtt = (7*p.srtt + sample.RTT) / 8
+		}
+		// the symbols are relayed and decoded at different times by every peer, single samples are too noisy
+		p.rttSamples++
+		if p.rttSamples >= rttWarmup {
+			if p.minRTT == 0 || p.srtt < p.minRTT {
+				p.minRTT = p.srtt
+			}
+			congested = congested || float64(p.srtt) > rttTolerance*float64(p.minRTT)
+		}
+	}
+	switch {
+	case !congested:
+		p.speed += opts.Increase
+	case sample.Time.Sub(p.lastDecrease) > p.srtt:
+		p.speed *= opts.Decrease
+		p.lastDecrease = sample.Time
+	}
+	p.speed = math.Min(math.Max(p.speed, opts.MinSpeed), opts.MaxSpeed)
+}
@@ -0,0 +1,221 @@
+package coopcast
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// schedule returns the delays of the first symbols of a sequence paced by pacer
+func schedule(pacer Pacer, k int, n int) []time.Duration {
+	delays := make([]time.Duration, n)
+	prev := testStart
+	for i := range delays {
+		next := pacer.Next(prev, i, k)
+		delays[i] = next.Sub(prev)
+		prev = next
+	}
+	return delays
+}
+
+// TestPacerSchedules checks the delays of the constant, linear and exponential pacers
+func TestPacerSchedules(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name   string
+		pacer  Pacer
+		k      int
+		delays []time.Duration
+	}{
+		{"constant", ConstantPacer{Interval: 10 * ms}, 2, []time.Duration{10 * ms, 10 * ms, 10 * ms, 10 * ms}},
+		{"constant relay", ConstantPacer{Interval: 10 * ms}, 0, []time.Duration{10 * ms, 10 * ms, 10 * ms}},
+		{"linear", LinearPacer{Interval: 10 * ms, Step: 2 * ms, Max: 15 * ms}, 2, []time.Duration{10 * ms, 10 * ms, 10 * ms, 12 * ms, 14 * ms, 15 * ms, 15 * ms}},
+		{"linear uncapped", LinearPacer{Interval: 10 * ms, Step: 2 * ms}, 0, []time.Duration{10 * ms, 12 * ms, 14 * ms, 16 * ms}},
+		{"exponential", ExponentialPacer{Interval: 10 * ms, Max: 40 * ms, Base: 2}, 2, []time.Duration{10 * ms, 10 * ms, 10 * ms, 20 * ms, 40 * ms, 40 * ms}},
+		{"exponential max below interval", ExponentialPacer{Interval: 10 * ms, Max: 5 * ms, Base: 2}, 0, []time.Duration{10 * ms, 10 * ms, 10 * ms}},
+	}
+	for _, test := range tests {
+		if delays := schedule(test.pacer, test.k, len(test.delays)); !reflect.DeepEqual(delays, test.delays) {
+			t.Errorf("%v: delays %v, want %v", test.name, delays, test.delays)
+		}
+	}
+}
+
+// TestTokenBucketPacer checks that the token bucket sends a burst at once, then one symbol per interval
+// whatever the sequence of the symbols, and that an idle bucket refills
+func TestTokenBucketPacer(t *testing.T) {
+	ms := time.Millisecond
+	pacer := NewTokenBucketPacer(100, 3)
+	// a relay batch sent to every neighbor at once, in the future so that the clock does not move the schedule
+	start := time.Now().Add(time.Hour)
+	var due []time.Duration
+	for n := 0; n < 5; n++ {
+		due = append(due, pacer.Next(start, n%2, 0).Sub(start))
+	}
+	if want := []time.Duration{0, 0, 0, 10 * ms, 20 * ms}; !reflect.DeepEqual(due, want) {
+		t.Errorf("symbols due after %v, want %v", due, want)
+	}
+	later := start.Add(time.Second)
+	if next := pacer.Next(later, 0, 0); !next.Equal(later) {
+		t.Errorf("symbol of an idle bucket due %v late", next.Sub(later))
+	}
+	// the tokens of the past are lost
+	before := time.Now()
+	if next := NewTokenBucketPacer(100, 3).Next(testStart, 0, 0); next.Before(before) {
+		t.Errorf("symbol due in the past")
+	}
+	if pacer := NewTokenBucketPacer(100, 0).(*tokenBucketPacer); pacer.bucket.burst != 1 {
+		t.Errorf("bucket of no burst sends %v symbols at once, want 1", pacer.bucket.burst)
+	}
+}
+
+// TestAdaptivePacerNext checks that the adaptive pacer runs its schedule at its speed
+func TestAdaptivePacerNext(t *testing.T) {
+	pacer := NewAdaptivePacer(ExponentialPacer{Interval: 10 * time.Millisecond, Max: 40 * time.Millisecond, Base: 2}, PacingOptions{Increase: 1})
+	if delays := schedule(pacer, 1, 3); !reflect.DeepEqual(delays, []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond}) {
+		t.Errorf("delays %v at speed 1, want the schedule", delays)
+	}
+	pacer.OnAck(AckSample{Time: testStart})
+	if delays := schedule(pacer, 1, 3); !reflect.DeepEqual(delays, []time.Duration{5 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond}) {
+		t.Errorf("delays %v at speed 2, want half the schedule", delays)
+	}
+}
+
+// TestAdaptivePacerIncrease checks that the speed grows by Increase with every acknowledgement without loss,
+// up to MaxSpeed
+func TestAdaptivePacerIncrease(t *testing.T) {
+	opts := PacingOptions{}.adaptiveOrDefault(10)
+	if !closeTo(opts.Increase, 0.01) {
+		t.Fatalf("increase %v, want a tenth over 10 acknowledgements", opts.Increase)
+	}
+	pacer := NewAdaptivePacer(ConstantPacer{}, opts)
+	for i := 1; i <= 10; i++ {
+		pacer.OnAck(AckSample{Time: testStart.Add(time.Duration(i) * time.Millisecond)})
+		if speed := pacer.Speed(); !closeTo(speed, 1+float64(i)*opts.Increase) {
+			t.Fatalf("speed %v after %v acknowledgements, want %v", speed, i, 1+float64(i)*opts.Increase)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		pacer.OnAck(AckSample{Time: testStart.Add(time.Second)})
+	}
+	if speed := pacer.Speed(); speed != opts.MaxSpeed {
+		t.Errorf("speed %v, want at most %v", speed, opts.MaxSpeed)
+	}
+}
+
+// TestAdaptivePacerLossDecrease checks that the speed is multiplied by Decrease at most once per round trip
+// when the smoothed loss rate goes LossTarget above its lowest value, down to MinSpeed
+func TestAdaptivePacerLossDecrease(t *testing.T) {
+	pacer := NewAdaptivePacer(ConstantPacer{}, PacingOptions{Increase: 0.1})
+	opts := pacer.opts
+	pacer.OnAck(AckSample{Time: testStart})
+	if speed := pacer.Speed(); !closeTo(speed, 1.1) {
+		t.Fatalf("speed %v after an acknowledgement without loss, want 1.1", speed)
+	}
+	// every symbol missed: the smoothed loss rate grows to 1/8, above the lowest 0 by more than 0.1
+	now := testStart.Add(time.Millisecond)
+	pacer.OnAck(AckSample{Loss: 1, Time: now})
+	if speed := pacer.Speed(); !closeTo(speed, 1.1*opts.Decrease) {
+		t.Errorf("speed %v after a loss, want %v", speed, 1.1*opts.Decrease)
+	}
+	pacer.OnAck(AckSample{Loss: 1, Time: now})
+	if speed := pacer.Speed(); !closeTo(speed, 1.1*opts.Decrease) {
+		t.Errorf("speed %v after a second loss in the same round trip, want %v", speed, 1.1*opts.Decrease)
+	}
+	now = now.Add(time.Millisecond)
+	pacer.OnAck(AckSample{Loss: 1, Time: now})
+	if speed := pacer.Speed(); !closeTo(speed, 1.1*opts.Decrease*opts.Decrease) {
+		t.Errorf("speed %v after a loss in the next round trip, want %v", speed, 1.1*opts.Decrease*opts.Decrease)
+	}
+	for i := 0; i < 100; i++ {
+		now = now.Add(time.Millisecond)
+		pacer.OnAck(AckSample{Loss: 1, Time: now})
+	}
+	if speed := pacer.Speed(); speed != opts.MinSpeed {
+		t.Errorf("speed %v, want at least %v", speed, opts.MinSpeed)
+	}
+}
+
+// TestAdaptivePacerRTTDecrease checks that the speed decreases once per smoothed round trip time when it grows
+// above twice its lowest value, and that single samples are not trusted
+func TestAdaptivePacerRTTDecrease(t *testing.T) {
+	pacer := NewAdaptivePacer(ConstantPacer{}, PacingOptions{Increase: 0.1})
+	opts := pacer.opts
+	now := testStart
+	// a slow round trip before the warmup is not a congestion
+	pacer.OnAck(AckSample{RTT: 10 * time.Millisecond, Time: now})
+	for i := 1; i < rttWarmup; i++ {
+		now = now.Add(time.Millisecond)
+		pacer.OnAck(AckSample{RTT: 10 * time.Millisecond, Time: now})
+	}
+	speed := 1 + float64(rttWarmup)*opts.Increase
+	if got := pacer.Speed(); !closeTo(got, speed) {
+		t.Fatalf("speed %v after %v acknowledgements without loss, want %v", got, rttWarmup, speed)
+	}
+	// a round trip of 200ms brings the smoothed one to 33.75ms, above twice the lowest 10ms
+	now = now.Add(time.Millisecond)
+	pacer.OnAck(AckSample{RTT: 200 * time.Millisecond, Time: now})
+	speed *= opts.Decrease
+	if got := pacer.Speed(); !closeTo(got, speed) {
+		t.Errorf("speed %v after the round trip time grew, want %v", got, speed)
+	}
+	// the smoothed round trip time is about 30ms, a decrease within it is skipped
+	pacer.OnAck(AckSample{RTT: 10 * time.Millisecond, Time: now.Add(10 * time.Millisecond)})
+	if got := pacer.Speed(); !closeTo(got, speed) {
+		t.Errorf("speed %v after a congestion in the same round trip, want %v", got, speed)
+	}
+	pacer.OnAck(AckSample{RTT: 10 * time.Millisecond, Time: now.Add(50 * time.Millisecond)})
+	if got := pacer.Speed(); !closeTo(got, speed*opts.Decrease) {
+		t.Errorf("speed %v after a congestion in the next round trip, want %v", got, speed*opts.Decrease)
+	}
+}
+
+// TestParsePacingStrategy checks that every strategy parses back from its name
+func TestParsePacingStrategy(t *testing.T) {
+	for _, strategy := range []PacingStrategy{PaceDefault, PaceExponential, PaceConstant, PaceLinear, PaceTokenBucket, PaceAdaptive} {
+		if parsed, err := ParsePacingStrategy(strategy.String()); err != nil || parsed != strategy {
+			t.Errorf("ParsePacingStrategy(%q) = %v, %v, want %v", strategy.String(), parsed, err, strategy)
+		}
+	}
+	if _, err := ParsePacingStrategy("fast"); err == nil {
+		t.Errorf("ParsePacingStrategy(fast) succeeded")
+	}
+	if s := PacingStrategy(9).String(); s != "PacingStrategy(9)" {
+		t.Errorf("unknown strategy printed as %q", s)
+	}
+}
+
+// TestNewPacer checks the pacer built for every strategy and the defaults of its parameters
+func TestNewPacer(t *testing.T) {
+	ms := time.Millisecond
+	custom := ConstantPacer{Interval: ms}
+	tests := []struct {
+		name  string
+		opts  PacingOptions
+		pacer Pacer
+	}{
+		{"default", PacingOptions{}, ExponentialPacer{Interval: 10 * ms, Max: 100 * ms, Base: 1.5}},
+		{"exponential", PacingOptions{Strategy: PaceExponential, Interval: 20 * ms, Base: 2}, ExponentialPacer{Interval: 20 * ms, Max: 100 * ms, Base: 2}},
+		{"constant", PacingOptions{Strategy: PaceConstant}, ConstantPacer{Interval: 10 * ms}},
+		{"linear", PacingOptions{Strategy: PaceLinear}, LinearPacer{Interval: 10 * ms, Step: ms, Max: 100 * ms}},
+		{"linear step", PacingOptions{Strategy: PaceLinear, Step: 3 * ms, MaxInterval: 50 * ms}, LinearPacer{Interval: 10 * ms, Step: 3 * ms, Max: 50 * ms}},
+		{"token bucket", PacingOptions{Strategy: PaceTokenBucket}, &tokenBucketPacer{bucket: &gcra{rate: 100, burst: float64(sendBatchSize)}}},
+		{"token bucket rate", PacingOptions{Strategy: PaceTokenBucket, Rate: 1000, Burst: 4}, &tokenBucketPacer{bucket: &gcra{rate: 1000, burst: 4}}},
+		{"custom", PacingOptions{Strategy: PaceLinear, Pacer: custom}, custom},
+	}
+	for _, test := range tests {
+		if pacer := test.opts.newPacer(10*ms, 100*ms, 1.5, 4); !reflect.DeepEqual(pacer, test.pacer) {
+			t.Errorf("%v: pacer %#v, want %#v", test.name, pacer, test.pacer)
+		}
+	}
+	adaptive, ok := PacingOptions{Strategy: PaceAdaptive}.newPacer(10*ms, 100*ms, 1.5, 4).(*AdaptivePacer)
+	if !ok {
+		t.Fatalf("adaptive strategy is not paced by an AdaptivePacer")
+	}
+	if schedule := (ExponentialPacer{Interval: 10 * ms, Max: 100 * ms, Base: 1.5}); adaptive.schedule != schedule {
+		t.Errorf("adaptive schedule %#v, want %#v", adaptive.schedule, schedule)
+	}
+	if !closeTo(adaptive.opts.Increase, defaultIncrease/4) {
+		t.Errorf("adaptive increase %v, want a tenth over the 4 acknowledgements a chunk needs", adaptive.opts.Increase)
+	}
+}
@@ -0,0 +1,259 @@
+package coopcast
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// defaults of the pacers
+const (
+	defaultIncrease   float64 = 0.1 // speed added once a chunk is acknowledged by enough peers
+	defaultDecrease   float64 = 0.7
+	defaultLossTarget float64 = 0.1
+	defaultMinSpeed   float64 = 0.25
+	defaultMaxSpeed   float64 = 4
+	defaultLinearStep float64 = 0.1 // fraction of the interval added per symbol
+	rttTolerance      float64 = 2   // smoothed round trip times above rttTolerance times the smallest one signal a queue
+	rttWarmup         int     = 8   // round trip samples before the smoothed round trip time is trusted
+	sendTimesSize     int     = 4096
+)
+
+// PacingStrategy selects a built-in Pacer
+type PacingStrategy int
+
+// built-in pacers
+const (
+	PaceDefault     PacingStrategy = iota // PaceExponential for broadcasts, PaceConstant for relays
+	PaceExponential                       // ExponentialPacer
+	PaceConstant                          // ConstantPacer
+	PaceLinear                            // LinearPacer
+	PaceTokenBucket                       // NewTokenBucketPacer
+	PaceAdaptive                          // NewAdaptivePacer of an ExponentialPacer
+)
+
+func (s PacingStrategy) String() string {
+	switch s {
+	case PaceDefault:
+		return "default"
+	case PaceExponential:
+		return "exponential"
+	case PaceConstant:
+		return "constant"
+	case PaceLinear:
+		return "linear"
+	case PaceTokenBucket:
+		return "token-bucket"
+	case PaceAdaptive:
+		return "adaptive"
+	}
+	return fmt.Sprintf("PacingStrategy(%d)", int(s))
+}
+
+// ParsePacingStrategy returns the PacingStrategy named s, as printed by PacingStrategy.String
+func ParsePacingStrategy(s string) (PacingStrategy, error) {
+	for _, strategy := range []PacingStrategy{PaceDefault, PaceExponential, PaceConstant, PaceLinear, PaceTokenBucket, PaceAdaptive} {
+		if strategy.String() == s {
+			return strategy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown pacing strategy %v", s)
+}
+
+// PacingOptions selects the pacer of the symbols broadcast or relayed by a node. A broadcast creates its own pacer,
+// shared by all its chunks, and MaxRate caps the symbols sent by all the chunks together, so that a large message
+// does not flood the network whatever its window. The unset durations default to the delay parameters of the node:
+// InitialDelayTime, MaxDelayTime and ExpBase for broadcasts, RelayTime for relays.
+type PacingOptions struct {
+	Strategy    PacingStrategy
+	Pacer       Pacer         // used in place of Strategy when set, shared by every broadcast of the node
+	Interval    time.Duration // first delay of the constant, linear and exponential pacers
+	MaxInterval time.Duration // longest delay of the linear and exponential pacers
+	Step        time.Duration // delay added per symbol by the linear pacer, a tenth of Interval if unset
+	Base        float64       // factor applied per symbol by the exponential pacer
+	Rate        float64       // symbols per second of the token bucket pacer, one per Interval if unset
+	Burst       int           // symbols sent at once by the token bucket pacer, a batch if unset
+	MaxRate     float64       // symbols per second of a whole broadcast, unlimited if unset, ignored by relays
+
+	// adaptive pacer, see AdaptivePacer
+	Increase   float64 // speed added per acknowledgement, a tenth over the acknowledgements a chunk needs if unset
+	Decrease   float64 // factor applied to the speed on congestion, 0.7 if unset
+	LossTarget float64 // tolerated loss rate above the lowest one reported, 0.1 if unset
+	MinSpeed   float64 // slowest speed of the schedule, 0.25 if unset
+	MaxSpeed   float64 // fastest speed of the schedule, 4 if unset
+}
+
+// adaptiveOrDefault fills the unset parameters of the adaptive pacer, acks is the number of acknowledgements a chunk needs
+func (opts PacingOptions) adaptiveOrDefault(acks int) PacingOptions {
+	if opts.Increase <= 0 {
+		opts.Increase = defaultIncrease / math.Max(float64(acks), 1)
+	}
+	if opts.Decrease <= 0 || opts.Decrease >= 1 {
+		opts.Decrease = defaultDecrease
+	}
+	if opts.LossTarget <= 0 {
+		opts.LossTarget = defaultLossTarget
+	}
+	if opts.MinSpeed <= 0 {
+		opts.MinSpeed = defaultMinSpeed
+	}
+	if opts.MaxSpeed < opts.MinSpeed {
+		opts.MaxSpeed = math.Max(defaultMaxSpeed, opts.MinSpeed)
+	}
+	return opts
+}
+
+// newPacer creates the pacer of the options, the unset parameters default to the delays of the node
+// and acks is the number of acknowledgements a chunk needs
+func (opts PacingOptions) newPacer(interval time.Duration, maxInterval time.Duration, base float64, acks int) Pacer {
+	if opts.Pacer != nil {
+		return opts.Pacer
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = interval
+	}
+	if opts.MaxInterval <= 0 {
+		opts.MaxInterval = maxInterval
+	}
+	if opts.Base <= 0 {
+		opts.Base = base
+	}
+	switch opts.Strategy {
+	case PaceConstant:
+		return ConstantPacer{Interval: opts.Interval}
+	case PaceLinear:
+		step := opts.Step
+		if step <= 0 {
+			step = time.Duration(defaultLinearStep * float64(opts.Interval))
+		}
+		return LinearPacer{Interval: opts.Interval, Step: step, Max: opts.MaxInterval}
+	case PaceTokenBucket:
+		rate, burst := opts.Rate, opts.Burst
+		if rate <= 0 {
+			rate = float64(time.Second) / math.Max(float64(opts.Interval), 1)
+		}
+		if burst <= 0 {
+			burst = sendBatchSize
+		}
+		return NewTokenBucketPacer(rate, burst)
+	case PaceAdaptive:
+		schedule := ExponentialPacer{Interval: opts.Interval, Max: opts.MaxInterval, Base: opts.Base}
+		return NewAdaptivePacer(schedule, opts.adaptiveOrDefault(acks))
+	}
+	return ExponentialPacer{Interval: opts.Interval, Max: opts.MaxInterval, Base: opts.Base}
+}
+
+// milliseconds converts the delay parameters of a node
+func milliseconds(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// rateController paces the symbols of every chunk of a broadcast. The chunk goroutines schedule their symbols with
+// the pacer of the broadcast and take a token per symbol from a bucket of sendBatchSize tokens refilled at MaxRate.
+// It keeps the send times of the last symbols to measure the round trip times of the acknowledgements.
+type rateController struct {
+	pacer    Pacer
+	observer AckObserver // the pacer if it adapts to the acknowledgements
+	maxRate  float64
+
+	mux       sync.Mutex
+	tokens    float64
+	refilled  time.Time
+	sendTimes map[int]*[sendTimesSize]int64 // send time of the last symbols of every chunk, UnixNano time
+}
+
+func newRateController(pacer Pacer, maxRate float64) *rateController {
+	observer, _ := pacer.(AckObserver)
+	return &rateController{
+		pacer:     pacer,
+		observer:  observer,
+		maxRate:   maxRate,
+		tokens:    float64(sendBatchSize),
+		refilled:  time.Now(),
+		sendTimes: make(map[int]*[sendTimesSize]int64),
+	}
+}
+
+// refill adds the tokens accumulated since the last refill, caller must hold controller.mux
+func (controller *rateController) refill(now time.Time) {
+	elapsed := now.Sub(controller.refilled).Seconds()
+	controller.refilled = now
+	if elapsed > 0 {
+		controller.tokens = math.Min(controller.tokens+elapsed*controller.maxRate, float64(sendBatchSize))
+	}
+}
+
+// next returns when the symbol n of a chunk of k source symbols is due, the symbol n-1 was due at prev
+func (controller *rateController) next(prev time.Time, n int, k int) time.Time {
+	return controller.pacer.Next(prev, n, k)
+}
+
+// take consumes the token of a symbol and records its send time, it returns false if no token is left
+func (controller *rateController) take(chunkID int, symbolID uint32, now time.Time) bool {
+	controller.mux.Lock()
+	defer controller.mux.Unlock()
+	if controller.maxRate > 0 {
+		controller.refill(now)
+		if controller.tokens < 1 {
+			return false
+		}
+		controller.tokens--
+	}
+	if controller.observer != nil {
+		times := controller.sendTimes[chunkID]
+		if times == nil {
+			times = new([sendTimesSize]int64)
+			controller.sendTimes[chunkID] = times
+		}
+		times[symbolID%uint32(sendTimesSize)] = now.UnixNano()
+	}
+	return true
+}
+
+// ready returns when the next token is available
+func (controller *rateController) ready(now time.Time) time.Time {
+	controller.mux.Lock()
+	defer controller.mux.Unlock()
+	if controller.maxRate <= 0 {
+		return now
+	}
+	controller.refill(now)
+	if controller.tokens >= 1 {
+		return now
+	}
+	return now.Add(time.Duration((1 - controller.tokens) / controller.maxRate * float64(time.Second)))
+}
+
+// forget drops the send times of a chunk which is not broadcast anymore
+func (controller *rateController) forget(chunkID int) {
+	controller.mux.Lock()
+	defer controller.mux.Unlock()
+	delete(controller.sendTimes, chunkID)
+}
+
+// onAck passes the reception a peer reported when it decoded a chunk to the pacer
+func (controller *rateController) onAck(chunkID int, received uint32, maxSymbolID uint32, now time.Time) {
+	if controller.observer == nil {
+		return
+	}
+	// a peer which decoded a chunk after maxSymbolID symbols missed the other ones, or received them too late
+	sample := AckSample{ChunkID: chunkID, Loss: math.Max(1-float64(received)/(float64(maxSymbolID)+1), 0), Time: now}
+	controller.mux.Lock()
+	// the symbol with the highest ID was the last one received, if it is still in the ring
+	if times := controller.sendTimes[chunkID]; times != nil {
+		if sent := times[maxSymbolID%uint32(sendTimesSize)]; sent > 0 && sent <= now.UnixNano() {
+			sample.RTT = time.Duration(now.UnixNano() - sent)
+		}
+	}
+	controller.mux.Unlock()
+	controller.observer.OnAck(sample)
+}
+
+// currentSpeed returns the speed of an adaptive pacer, 1 for the other pacers
+func (controller *rateController) currentSpeed() float64 {
+	if adaptive, ok := controller.pacer.(*AdaptivePacer); ok {
+		return adaptive.Speed()
+	}
+	return 1
+}
@@ -0,0 +1,122 @@
+package coopcast
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testStart is the fixed time the pacing tests start at
+var testStart = time.Unix(1000, 0)
+
+// newTestController returns a controller whose bucket was last refilled at testStart
+func newTestController(pacer Pacer, maxRate float64) *rateController {
+	controller := newRateController(pacer, maxRate)
+	controller.refilled = testStart
+	return controller
+}
+
+// closeTo compares speeds up to the rounding of their computation
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// recordingPacer is a ConstantPacer keeping the acknowledgements it observes
+type recordingPacer struct {
+	ConstantPacer
+	samples []AckSample
+}
+
+func (p *recordingPacer) OnAck(sample AckSample) {
+	p.samples = append(p.samples, sample)
+}
+
+// TestRateControllerMaxRate checks that the symbols of all the chunks share a bucket refilled at MaxRate
+func TestRateControllerMaxRate(t *testing.T) {
+	controller := newTestController(ConstantPacer{}, 100)
+	for i := 0; i < sendBatchSize; i++ {
+		if !controller.take(i%3, uint32(i), testStart) {
+			t.Fatalf("symbol %v of a full bucket refused", i)
+		}
+	}
+	if controller.take(0, 100, testStart) {
+		t.Errorf("symbol sent from an empty bucket")
+	}
+	if ready, want := controller.ready(testStart), testStart.Add(10*time.Millisecond); !ready.Equal(want) {
+		t.Errorf("next token at %v, want %v", ready.Sub(testStart), want.Sub(testStart))
+	}
+	now := testStart.Add(10 * time.Millisecond)
+	if !controller.take(1, 101, now) || controller.take(2, 102, now) {
+		t.Errorf("bucket refilled with other than one token in 10ms at 100 symbols per second")
+	}
+	// an idle broadcast does not save more than a batch of tokens
+	now = now.Add(time.Hour)
+	for i := 0; i < sendBatchSize; i++ {
+		if !controller.take(0, uint32(200+i), now) {
+			t.Fatalf("symbol %v of a refilled bucket refused", i)
+		}
+	}
+	if controller.take(0, 300, now) {
+		t.Errorf("bucket holds more than %v tokens", sendBatchSize)
+	}
+
+	unlimited := newTestController(ConstantPacer{}, 0)
+	for i := 0; i < 10*sendBatchSize; i++ {
+		if !unlimited.take(0, uint32(i), testStart) {
+			t.Fatalf("symbol %v refused without MaxRate", i)
+		}
+	}
+	if ready := unlimited.ready(testStart); !ready.Equal(testStart) {
+		t.Errorf("next token in %v without MaxRate", ready.Sub(testStart))
+	}
+	if len(unlimited.sendTimes) != 0 {
+		t.Errorf("send times recorded for a pacer which ignores the acknowledgements")
+	}
+}
+
+// TestRateControllerAckSample checks the loss and the round trip time an acknowledgement reports to the pacer
+func TestRateControllerAckSample(t *testing.T) {
+	pacer := &recordingPacer{}
+	controller := newTestController(pacer, 0)
+	sent := func(symbolID uint32) time.Time { return testStart.Add(time.Duration(symbolID) * time.Millisecond) }
+	for symbolID := uint32(0); symbolID < 20; symbolID++ {
+		controller.take(0, symbolID, sent(symbolID))
+	}
+	now := sent(19).Add(30 * time.Millisecond)
+	controller.onAck(0, 20, 19, now)
+	controller.onAck(0, 15, 19, now)
+	controller.onAck(0, 30, 19, now)
+	controller.onAck(0, 13, 25, now)
+	controller.onAck(1, 20, 19, now)
+	controller.forget(0)
+	controller.onAck(0, 20, 19, now)
+	want := []AckSample{
+		{ChunkID: 0, Loss: 0, RTT: 30 * time.Millisecond, Time: now},
+		{ChunkID: 0, Loss: 0.25, RTT: 30 * time.Millisecond, Time: now},
+		{ChunkID: 0, Loss: 0, RTT: 30 * time.Millisecond, Time: now},
+		{ChunkID: 0, Loss: 0.5, Time: now}, // symbol 25 was not sent
+		{ChunkID: 1, Loss: 0, Time: now},   // no symbol of chunk 1 was sent
+		{ChunkID: 0, Loss: 0, Time: now},   // chunk 0 is not broadcast anymore
+	}
+	if !reflect.DeepEqual(pacer.samples, want) {
+		t.Errorf("samples %+v, want %+v", pacer.samples, want)
+	}
+}
+
+// TestRateControllerSpeed checks that the acknowledgements change the speed of an adaptive pacer only
+func TestRateControllerSpeed(t *testing.T) {
+	adaptive := newTestController(NewAdaptivePacer(ConstantPacer{Interval: 3 * time.Millisecond}, PacingOptions{Increase: 0.5}), 0)
+	adaptive.onAck(0, 20, 19, testStart)
+	if speed := adaptive.currentSpeed(); speed != 1.5 {
+		t.Errorf("speed %v after an acknowledgement without loss, want 1.5", speed)
+	}
+	if next := adaptive.next(testStart, 0, 1); !next.Equal(testStart.Add(2 * time.Millisecond)) {
+		t.Errorf("next symbol after %v at speed 1.5, want 2ms", next.Sub(testStart))
+	}
+	fixed := newTestController(ConstantPacer{Interval: time.Millisecond}, 0)
+	fixed.onAck(0, 20, 19, testStart)
+	if speed := fixed.currentSpeed(); speed != 1 {
+		t.Errorf("speed %v without adaptive pacing, want 1", speed)
+	}
+}
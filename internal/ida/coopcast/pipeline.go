@@ -0,0 +1,255 @@
+package coopcast
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/udpbatch"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+	"hash/fnv"
+	"log"
+	"net"
+	"runtime"
+	"sync/atomic"
+)
+
+// default sizes of the receive pipeline
+const (
+	defaultQueueSize    int = 1024
+	defaultRelayWorkers int = 256
+)
+
+// PipelineOptions sizes the pipeline handling the received symbols. A reader goroutine drains the socket
+// into a dispatcher which parses the packets and shards them by (root hash, chunkID) over the workers,
+// so the symbols of a chunk are decoded in order by a single worker while the chunks are decoded in parallel.
+// Every queue is bounded, packets are dropped when one is full rather than stalling the socket reads.
+// The relay queue is a priority queue served by a fixed pool of relay workers, see RelayDropPolicy.
+type PipelineOptions struct {
+	Workers      int             // decoding workers, runtime.NumCPU() if unset
+	QueueSize    int             // capacity of the dispatcher queue, of every worker queue and of the relay queue, 1024 if unset
+	RelayWorkers int             // goroutines relaying symbols to the neighbors, 256 if unset
+	RelayPolicy  RelayDropPolicy // symbol dropped when the relay queue is full
+}
+
+func (opts PipelineOptions) orDefault() PipelineOptions {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.RelayWorkers <= 0 {
+		opts.RelayWorkers = defaultRelayWorkers
+	}
+	return opts
+}
+
+// PipelineStats reports the load of the receive pipeline, the drop counters grow when the node cannot keep up
+type PipelineStats struct {
+	Received      uint64 // datagrams read from the socket
+	ReaderDrops   uint64 // datagrams dropped because the dispatcher queue was full
+	DispatchDrops uint64 // packets dropped because the queue of their worker was full
+	RelayDrops    uint64 // symbols not relayed because the relay queue was full, as chosen by RelayPolicy
+	InboundQueue  int    // datagrams waiting for the dispatcher
+	WorkerQueue   int    // packets waiting in the worker queues
+	RelayQueue    int    // symbols waiting for a relay worker
+	RelayPeak     int    // most symbols waiting for a relay worker at once
+}
+
+// PipelineStats returns a snapshot of the receive pipeline load
+func (node *Node) PipelineStats() PipelineStats {
+	relayQueue, relayPeak := node.pipeline.relays.depth()
+	stats := PipelineStats{
+		Received:      atomic.LoadUint64(&node.pipelineStats.Received),
+		ReaderDrops:   atomic.LoadUint64(&node.pipelineStats.ReaderDrops),
+		DispatchDrops: atomic.LoadUint64(&node.pipelineStats.DispatchDrops),
+		RelayDrops:    atomic.LoadUint64(&node.pipelineStats.RelayDrops),
+		InboundQueue:  len(node.pipeline.inbound),
+		RelayQueue:    relayQueue,
+		RelayPeak:     relayPeak,
+	}
+	for _, shard := range node.pipeline.shards {
+		stats.WorkerQueue += len(shard)
+	}
+	return stats
+}
+
+type inboundPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+type symbolTask struct {
+	packet *wire.SymbolPacket
+	notice *wire.AckPacket // decoded chunk announced by a neighbor, in place of packet
+	addr   net.Addr
+}
+
+// pipeline holds the queues between the stages of the receive pipeline
+type pipeline struct {
+	inbound chan inboundPacket
+	shards  []chan symbolTask
+	relays  *relayQueue
+	notices chan []byte // acknowledgements of the decoded chunks announced to the neighbors
+}
+
+func newPipeline(opts PipelineOptions) *pipeline {
+	p := &pipeline{
+		inbound: make(chan inboundPacket, opts.QueueSize),
+		shards:  make([]chan symbolTask, opts.Workers),
+		relays:  newRelayQueue(opts.QueueSize, opts.RelayPolicy),
+		notices: make(chan []byte, opts.QueueSize),
+	}
+	for i := range p.shards {
+		p.shards[i] = make(chan symbolTask, opts.QueueSize)
+	}
+	return p
+}
+
+// shard returns the worker queue of a chunk
+func (p *pipeline) shard(rootHash []byte, chunkID uint32) chan symbolTask {
+	h := fnv.New32a()
+	h.Write(rootHash)
+	var id [4]byte
+	binary.BigEndian.PutUint32(id[:], chunkID)
+	h.Write(id[:])
+	return p.shards[h.Sum32()%uint32(len(p.shards))]
+}
+
+// Gossip receives the symbols sent to pc, decodes and relays them until ctx is done.
+// It reads the socket itself and runs the other stages of the pipeline in goroutines of the node.
+func (node *Node) Gossip(ctx context.Context, pc net.PacketConn) {
+	node.goroutine(func() { node.dispatch(ctx) })
+	for _, shard := range node.pipeline.shards {
+		shard := shard
+		node.goroutine(func() { node.decodeWorker(ctx, shard) })
+	}
+	for i := 0; i < node.Pipeline.RelayWorkers; i++ {
+		node.goroutine(func() { node.relayWorker(ctx, pc) })
+	}
+
+	conn := udpbatch.NewConn(pc)
+	batch := make([]udpbatch.Message, readBatchSize)
+	for i := range batch {
+		batch[i].Buf = make([]byte, udpCacheSize)
+	}
+	for {
+		n, err := conn.ReadBatch(batch)
+		if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("gossip receive response with error %s", err)
+			continue
+		}
+		atomic.AddUint64(&node.pipelineStats.Received, uint64(n))
+		for _, msg := range batch[:n] {
+			copybuffer := make([]byte, msg.N)
+			copy(copybuffer, msg.Buf[:msg.N])
+			select {
+			case node.pipeline.inbound <- inboundPacket{data: copybuffer, addr: msg.Addr}:
+			default:
+				atomic.AddUint64(&node.pipelineStats.ReaderDrops, 1)
+			}
+		}
+	}
+}
+
+// dispatch parses the received datagrams and hands them over to the worker of their chunk
+func (node *Node) dispatch(ctx context.Context) {
+	for {
+		var in inboundPacket
+		select {
+		case <-ctx.Done():
+			return
+		case in = <-node.pipeline.inbound:
+		}
+		if node.isBlacklisted(in.addr.String()) {
+			continue
+		}
+		if t, err := wire.PeekType(in.data); err == nil && t == wire.TypeAck {
+			node.dispatchNotice(in)
+			continue
+		}
+		packet := &wire.SymbolPacket{}
+		if err := packet.UnmarshalBinary(in.data); err != nil {
+			atomic.AddUint64(&node.authStats.Malformed, 1)
+			log.Printf("gossip dropped malformed packet of %v bytes from %v: %v", len(in.data), in.addr, err)
+			continue
+		}
+		select {
+		case node.pipeline.shard(packet.RootHash, packet.ChunkID) <- symbolTask{packet: packet, addr: in.addr}:
+		default:
+			atomic.AddUint64(&node.pipelineStats.DispatchDrops, 1)
+		}
+	}
+}
+
+// dispatchNotice hands over the decoded chunk announced by a neighbor to the worker of the chunk
+func (node *Node) dispatchNotice(in inboundPacket) {
+	notice := &wire.AckPacket{}
+	if err := notice.UnmarshalBinary(in.data); err != nil {
+		atomic.AddUint64(&node.authStats.Malformed, 1)
+		log.Printf("gossip dropped malformed notice of %v bytes from %v: %v", len(in.data), in.addr, err)
+		return
+	}
+	select {
+	case node.pipeline.shard(notice.RootHash, notice.ChunkID) <- symbolTask{notice: notice, addr: in.addr}:
+	default:
+		atomic.AddUint64(&node.pipelineStats.DispatchDrops, 1)
+	}
+}
+
+func (node *Node) decodeWorker(ctx context.Context, shard chan symbolTask) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case task := <-shard:
+			if task.notice != nil {
+				node.handleNotice(task.notice)
+				continue
+			}
+			node.handleSymbol(task.packet, task.addr)
+		}
+	}
+}
+
+// relay queues a received symbol to be relayed to the peers of the fanout policy, received is the number of
+// symbols of its chunk the node received before
+func (node *Node) relay(packet *wire.SymbolPacket, received uint32) {
+	if packet.Hop == 0 || !node.hasRelayPeers() {
+		return
+	}
+	if !node.pipeline.relays.push(packet, received) {
+		atomic.AddUint64(&node.pipelineStats.RelayDrops, 1)
+	}
+}
+
+// relayWorker relays the queued symbols, together with the ones queued meanwhile up to relayBatchSize
+func (node *Node) relayWorker(ctx context.Context, pc net.PacketConn) {
+	conn := udpbatch.NewConn(pc)
+	packets := make([]*wire.SymbolPacket, 0, relayBatchSize)
+	for {
+		packets = packets[:0]
+		select {
+		case <-ctx.Done():
+			return
+		case <-node.pipeline.relays.ready:
+			packets = append(packets, node.pipeline.relays.pop())
+		case notice := <-node.pipeline.notices:
+			node.sendNotice(ctx, conn, notice)
+			continue
+		}
+	drain:
+		for len(packets) < relayBatchSize {
+			select {
+			case <-node.pipeline.relays.ready:
+				packets = append(packets, node.pipeline.relays.pop())
+			default:
+				break drain
+			}
+		}
+		node.relayEncodedSymbols(ctx, conn, packets)
+	}
+}
@@ -0,0 +1,33 @@
+package coopcast
+
+import (
+	"runtime"
+	"testing"
+)
+
+// TestPipelineOptionsDefault checks that unset pipeline sizes are defaulted and set ones kept
+func TestPipelineOptionsDefault(t *testing.T) {
+	if opts := (PipelineOptions{}).orDefault(); opts != (PipelineOptions{Workers: runtime.NumCPU(), QueueSize: defaultQueueSize, RelayWorkers: defaultRelayWorkers}) {
+		t.Errorf("zero options default to %+v", opts)
+	}
+	if opts := (PipelineOptions{Workers: 3, QueueSize: 5, RelayWorkers: 7}).orDefault(); opts != (PipelineOptions{Workers: 3, QueueSize: 5, RelayWorkers: 7}) {
+		t.Errorf("options changed to %+v", opts)
+	}
+}
+
+// TestPipelineShard checks that the symbols of a chunk always go to the same worker and the chunks are spread over all of them
+func TestPipelineShard(t *testing.T) {
+	p := newPipeline(PipelineOptions{Workers: 4, QueueSize: 1, RelayWorkers: 1})
+	root := make([]byte, hashSize)
+	used := make(map[chan symbolTask]bool)
+	for chunkID := uint32(0); chunkID < 64; chunkID++ {
+		shard := p.shard(root, chunkID)
+		if p.shard(root, chunkID) != shard {
+			t.Errorf("chunk %v sent to two workers", chunkID)
+		}
+		used[shard] = true
+	}
+	if len(used) != len(p.shards) {
+		t.Errorf("64 chunks spread over %v of %v workers", len(used), len(p.shards))
+	}
+}
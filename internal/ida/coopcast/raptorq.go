@@ -1,151 +1,165 @@
 package coopcast
 
 import (
-	"bufio"
+	"bytes"
 	"context"
-	"crypto/sha1"
-	"encoding/binary"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	raptorfactory "github.com/harmony-one/go-raptorq/pkg/defaults"
 	libraptorq "github.com/harmony-one/go-raptorq/pkg/raptorq"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/udpbatch"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
 	"io"
-	"io/ioutil"
 	"log"
 	"math"
-	"math/rand"
 	"net"
-	"strconv"
+	"sync/atomic"
 	"time"
 )
 
-// ListeningOnBroadCast listens and handle message received
-func (node *Node) ListeningOnBroadCast(pc net.PacketConn) {
-	go node.Gossip(pc)
-	go node.clearCache()
-
-	addr := net.JoinHostPort("", node.SelfPeer.TCPPort)
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		log.Printf("cannot listening to the port %s", node.SelfPeer.TCPPort)
-		return
-	}
-	log.Printf("server start listening on tcp port %s", node.SelfPeer.TCPPort)
+// BroadCast broadcast a message to peer nodes in the network
+func (node *Node) BroadCast(msg []byte, pc net.PacketConn) (BroadCastHandle, error) {
+	return node.BroadCastWith(msg, pc, BroadCastOptions{})
+}
 
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Printf("cannot accept connection")
-			return
-		}
-		clientinfo := conn.RemoteAddr().String()
-		log.Printf("accept connection from %s", clientinfo)
-		go node.handleResponse(conn)
-	}
+// BroadCastWith broadcast a message to peer nodes in the network with per broadcast options
+func (node *Node) BroadCastWith(msg []byte, pc net.PacketConn, opts BroadCastOptions) (BroadCastHandle, error) {
+	return node.BroadCastReader(bytes.NewReader(msg), int64(len(msg)), pc, opts)
 }
 
-// BroadCast broadcast a message to peer nodes in the network
-func (node *Node) BroadCast(msg []byte, pc net.PacketConn) (map[int]interface{}, *RaptorQImpl) {
+// BroadCastReader broadcast a message of size bytes read from r to peer nodes in the network.
+// The message is read once to compute its root hash, then the encoder of a chunk is created from r when
+// the chunk is broadcast and released when enough peers decoded it, so at most opts.Window chunks are in memory.
+func (node *Node) BroadCastReader(r io.ReaderAt, size int64, pc net.PacketConn, opts BroadCastOptions) (BroadCastHandle, error) {
+	if len(node.PeerList) == 0 {
+		return nil, errNoPeers
+	}
+	if len(node.privKey) != ed25519.PrivateKeySize {
+		return nil, errNoPrivateKey
+	}
+	if size <= 0 {
+		return nil, errEmptyMessage
+	}
+	params := node.Encoding
+	if opts.Encoding != (EncodingParams{}) {
+		params = opts.Encoding
+	}
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	window := opts.Window
+	if window <= 0 {
+		window = defaultWindow
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = stopBroadCastTime * time.Second
+	}
+	pacing := node.Pacing
+	if opts.Pacing != (PacingOptions{}) {
+		pacing = opts.Pacing
+	}
 	raptorq := RaptorQImpl{}
 	raptorq.threshold = int(threshold * float32(len(node.AllPeers)))
 	log.Printf("threshold value is %v", raptorq.threshold)
-	raptorq.senderID = node.SelfPeer.Sid
-	raptorq.rootHash = getRootHash(msg)
+	raptorq.sender = node.selfID
+	raptorq.hashType = node.HashType
 	raptorq.Encoder = make(map[int]libraptorq.Encoder)
 	raptorq.stats = make(map[int]float64)
-	raptorq.chunkSize = normalChunkSize
+	raptorq.params = params
+	raptorq.chunkSize = int(params.ChunkSize)
+	raptorq.source = r
+	raptorq.size = size
 	raptorq.initTime = time.Now().UnixNano()
 
-	hashkey := convertToFixedSize(raptorq.rootHash)
-	node.SenderCache[hashkey] = true
+	B := int64(raptorq.chunkSize)
+	raptorq.numChunks = int((size + B - 1) / B)
+	pacer := pacing.newPacer(milliseconds(node.InitialDelayTime), milliseconds(node.MaxDelayTime), node.ExpBase, raptorq.threshold)
+	raptorq.pacer = newRateController(pacer, pacing.MaxRate)
 
-	F := len(msg)
-	B := raptorq.chunkSize
-	if F <= B {
-		raptorq.numChunks = 1
-	} else if F%B == 0 {
-		raptorq.numChunks = F / B
-	} else {
-		raptorq.numChunks = F/B + 1
+	leaves := make([][]byte, raptorq.numChunks)
+	buf := make([]byte, raptorq.chunkSize)
+	for z := 0; z < raptorq.numChunks; z++ {
+		piece := buf[:raptorq.getChunkSize(z)]
+		if err := readChunk(r, int64(z)*B, piece); err != nil {
+			return nil, fmt.Errorf("cannot read chunkID=%v: %v", z, err)
+		}
+		leaves[z] = raptorq.hashType.chunkHash(piece)
 	}
-
-	cancels := make(map[int]interface{})
+	raptorq.rootHash = raptorq.hashType.merkleRoot(leaves)
+	raptorq.chunkHashes = make(map[int][]byte)
+	raptorq.chunkProofs = make(map[int][][]byte)
 	for z := 0; z < raptorq.numChunks; z++ {
-		ctx, cancel := context.WithCancel(context.Background())
-		cancels[z] = cancel
-		go node.broadCastEncodedSymbol(ctx, msg, &raptorq, pc, z)
+		raptorq.chunkHashes[z] = leaves[z]
+		raptorq.chunkProofs[z] = raptorq.hashType.merkleProof(leaves, z)
 	}
-	return cancels, &raptorq
-}
-
-// StopBroadCast controls when to stop sender from continuing broadcast
-func (node *Node) StopBroadCast(cancels map[int]interface{}, raptorq *RaptorQImpl) {
-	//stop := make(chan bool)
-	//go node.ReportUnfinishedBlocks(raptorq, stop)
 
 	hashkey := convertToFixedSize(raptorq.rootHash)
-	canceled := make(map[int]bool)
-	for start := time.Now(); time.Since(start) < stopBroadCastTime*time.Second; {
-		for z := 0; z < raptorq.numChunks; z++ {
-			if canceled[z] {
-				continue
-			}
-			if node.PeerDecodedCounter[hashkey][z] >= raptorq.threshold {
-				delta := float64(time.Now().UnixNano()-raptorq.initTime) / 1000000
-				raptorq.mux.Lock()
-				raptorq.stats[z] = delta
-				raptorq.mux.Unlock()
-				cancels[z].(context.CancelFunc)()
-				canceled[z] = true
-				log.Printf("***** chunkID %v canceled", z)
-			}
-		}
-		if len(canceled) >= raptorq.numChunks {
-			//stop <- true
-			log.Printf("t0/t1/base/t2/hop: %v ms, %v ms, %v, %v ms, %v", node.InitialDelayTime, node.MaxDelayTime, node.ExpBase, node.RelayTime, node.Hop)
-			for z, delta := range raptorq.stats {
-				log.Printf("block %v broadcast finished with time elapse = %v ms", z, delta)
-			}
-			log.Printf("total broadcast time: %v ms", float64(time.Now().UnixNano()-raptorq.initTime)/1000000)
-			return
-		}
-		time.Sleep(200 * time.Millisecond)
+	node.mux.Lock()
+	node.SenderCache[hashkey] = true
+	node.pacers[hashkey] = raptorq.pacer
+	node.mux.Unlock()
+
+	handle := newBroadCastHandle(node, &raptorq, timeout)
+	if !node.goroutine(func() { handle.dispatch(pc, window) }) || !node.goroutine(handle.monitor) {
+		handle.finish(ErrBroadCastCanceled)
+	}
+	return handle, nil
+}
+
+// readChunk fills buf with the bytes of r starting at offset
+func readChunk(r io.ReaderAt, offset int64, buf []byte) error {
+	n, err := r.ReadAt(buf, offset)
+	if n == len(buf) {
+		return nil
+	}
+	if err == nil {
+		err = io.ErrUnexpectedEOF
 	}
+	return err
 }
 
-func (node *Node) clearCache() {
+// clearCache periodically releases the cached decoding state until ctx is done
+func (node *Node) clearCache(ctx context.Context) {
 	OneSec := int64(1000000000)
-	node.mux.Lock()
-	locked := true
-	defer func() {
-		if locked {
-			node.mux.Unlock()
-		}
-	}()
+	ticker := time.NewTicker(cacheClearInterval * time.Second)
+	defer ticker.Stop()
 	for {
-		locked = false
-		node.mux.Unlock()
-		time.Sleep(cacheClearInterval * time.Second)
-		locked = true
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		node.mux.Lock()
 		currentTime := time.Now().UnixNano()
+		var evicted []*RaptorQImpl
 		for k, v := range node.Cache {
-			if v.successTime > 0 && currentTime-v.successTime > int64(cacheClearInterval)*OneSec {
+			successTime := atomic.LoadInt64(&v.successTime)
+			if successTime > 0 && currentTime-successTime > int64(cacheClearInterval)*OneSec {
+				node.uncharge(k, v, v.memory)
 				delete(node.Cache, k)
+				node.finished[k] = currentTime
+				evicted = append(evicted, v)
 				log.Printf("file hash %v cache deleted", k)
 			} else if currentTime-v.initTime > enforceClearInterval*OneSec {
+				node.uncharge(k, v, v.memory)
 				delete(node.Cache, k)
+				evicted = append(evicted, v)
 				log.Printf("file hash %v cache eventually deleted", k)
 			}
 		}
+		node.clearRejected(currentTime)
+		node.clearNeighborChunks(currentTime)
+		node.mux.Unlock()
+		node.clearTraffic(currentTime)
+		for _, raptorq := range evicted {
+			raptorq.release()
+		}
 	}
 }
 
-//return 20 byte of the sha1 sum of message
-func getRootHash(msg []byte) []byte {
-	x := sha1.Sum(msg)
-	return x[:]
-}
-
 func convertToFixedSize(buf []byte) [hashSize]byte {
 	var arr [hashSize]byte
 	copy(arr[:], buf[:hashSize])
@@ -153,147 +167,161 @@ func convertToFixedSize(buf []byte) [hashSize]byte {
 }
 
 func symDebug(prefix string, z int, esi uint32, symbol []byte) {
-	symhash := sha1.Sum(symbol)
+	symhash := sha256.Sum256(symbol)
 	symhh := make([]byte, hex.EncodedLen(len(symhash)))
 	hex.Encode(symhh, symhash[:])
 	log.Printf("%s: z=%+v esi=%+v len=%v symhh=%s", prefix, z, esi, len(symbol), symhh)
 }
 
-func (raptorq *RaptorQImpl) constructSymbolPacket(msg []byte, chunkID int, symbolID uint32, hop int) ([]byte, error) {
-	// |hashSize(20)|hop(1)|senderID(2)|numChunks(4)|chunkID(4)|chunkSize(4)|symbolID(4)|symbol(1200)|
-	T := raptorq.Encoder[chunkID].SymbolSize()
+// constructSymbolPacket encodes a symbol into an unsigned packet, see wire.SymbolPacket for the layout
+func (raptorq *RaptorQImpl) constructSymbolPacket(encoder libraptorq.Encoder, chunkID int, symbolID uint32, hop int) (*wire.SymbolPacket, error) {
+	T := encoder.SymbolSize()
 	symbol := make([]byte, int(T))
-	_, err := raptorq.Encoder[chunkID].Encode(0, symbolID, symbol)
+	_, err := encoder.Encode(0, symbolID, symbol)
 	if err != nil {
 		return nil, err
 	}
 	symDebug("encoded", chunkID, symbolID, symbol)
-	packet := make([]byte, 0)
-	packet = append(packet, raptorq.rootHash...)
-
-	packet = append(packet, byte(hop))
-
-	senderIDBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(senderIDBytes, uint16(raptorq.senderID))
-	packet = append(packet, senderIDBytes...)
-
-	numChunksBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(numChunksBytes, uint32(raptorq.numChunks))
-	packet = append(packet, numChunksBytes...)
-
-	chunkIDBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(chunkIDBytes, uint32(chunkID))
-	packet = append(packet, chunkIDBytes...)
-
-	chunkSize := raptorq.getChunkSize(msg, chunkID)
-	chunkSizeBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(chunkSizeBytes, uint32(chunkSize))
-	packet = append(packet, chunkSizeBytes...)
-
-	symbolIDBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(symbolIDBytes, symbolID)
-	packet = append(packet, symbolIDBytes...)
-	packet = append(packet, symbol...)
-
-	return packet, nil
+	packet := wire.SymbolPacket{
+		HashType:  byte(raptorq.hashType),
+		RootHash:  raptorq.rootHash,
+		Hop:       uint8(hop),
+		Sender:    raptorq.sender[:],
+		NumChunks: uint32(raptorq.numChunks),
+		ChunkID:   uint32(chunkID),
+		ChunkSize: uint32(raptorq.chunkSize),
+		ChunkHash: raptorq.chunkHashes[chunkID],
+		Proof:     raptorq.chunkProofs[chunkID],
+		SymbolID:  symbolID,
+		Symbol:    symbol,
+
+		CommonOTI:         encoder.CommonOTI(),
+		SchemeSpecificOTI: encoder.SchemeSpecificOTI(),
+	}
+	return &packet, nil
 }
 
 // Specification of RaptorQ FEC is defined in RFC6330
-// return the TransferLength/ChunkSize
-func (raptorq *RaptorQImpl) setEncoderIfNotExist(msg []byte, chunkID int) error {
-	if _, ok := raptorq.Encoder[chunkID]; ok {
-		return nil
+// setEncoderIfNotExist reads a chunk from the source of the message and creates its encoder
+func (raptorq *RaptorQImpl) setEncoderIfNotExist(chunkID int) (libraptorq.Encoder, error) {
+	raptorq.mux.Lock()
+	encoder, ok := raptorq.Encoder[chunkID]
+	raptorq.mux.Unlock()
+	if ok {
+		return encoder, nil
 	}
 
 	encf := raptorfactory.DefaultEncoderFactory()
 	// each source block, the size is limit to a 40 bit integer 946270874880 = 881.28 GB
 	//there are some hidden restrictions: WS/T >=10
 	// Al: symbol alignment parameter
-	var Al uint8 = 4
+	Al := raptorq.params.Alignment
 	// T: symbol size, can take it to be maximum payload size, multiple of Al
-	T := uint16(symbolSize)
-	// WS: working memory, maxSubBlockSize
-	WS := 2 * uint32(normalChunkSize)
-	// minimum sub-symbol size is SS, must be a multiple of Al
-	minSubSymbolSize := T // then N=1
+	T := raptorq.params.SymbolSize
+	// minimum sub-symbol size is SS, must be a multiple of Al, T/SS gives the number of sub-blocks N
+	minSubSymbolSize := T / raptorq.params.SubBlocks
+	// WS: working memory, maxSubBlockSize, a sub-block of the whole chunk fits in it when N=1
+	ws := 2 * uint64(raptorq.params.ChunkSize) / uint64(raptorq.params.SubBlocks)
+	if ws < 10*uint64(T) {
+		ws = 10 * uint64(T)
+	}
+	if ws > math.MaxUint32 {
+		ws = math.MaxUint32
+	}
+	WS := uint32(ws)
 
 	t0 := time.Now().UnixNano()
-	a := chunkID * normalChunkSize
-	b := a + raptorq.getChunkSize(msg, chunkID)
-	piece := msg[a:b]
+	piece := make([]byte, raptorq.getChunkSize(chunkID))
+	if err := readChunk(raptorq.source, int64(chunkID)*int64(raptorq.chunkSize), piece); err != nil {
+		return nil, err
+	}
 	encoder, err := encf.New(piece, T, minSubSymbolSize, WS, Al)
-	log.Printf("encoder for chunkID=%v is created with size %v", chunkID, b-a)
-	log.Printf("DEBUG:****** encoder for common: %v, specific: %v", encoder.CommonOTI(), encoder.SchemeSpecificOTI())
-	log.Printf("****: N: %v", encoder.NumSubBlocks())
-	log.Printf("****: Al: %v", encoder.SymbolAlignmentParameter())
-
-	if err == nil {
-		raptorq.Encoder[chunkID] = encoder
-	} else {
-		return err
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("numChunks=%v, chunkID=%v, numMinSymbols=%v", raptorq.numChunks, chunkID, raptorq.Encoder[chunkID].MinSymbols(0))
+	raptorq.mux.Lock()
+	raptorq.Encoder[chunkID] = encoder
+	raptorq.mux.Unlock()
+	log.Printf("encoder for chunkID=%v is created with size %v", chunkID, len(piece))
+	log.Printf("encoder common OTI: %v, specific OTI: %v, N: %v, Al: %v", encoder.CommonOTI(), encoder.SchemeSpecificOTI(), encoder.NumSubBlocks(), encoder.SymbolAlignmentParameter())
+	log.Printf("numChunks=%v, chunkID=%v, numMinSymbols=%v", raptorq.numChunks, chunkID, encoder.MinSymbols(0))
 	log.Printf("encoder for chunkID %v creation time is %v ms", chunkID, (time.Now().UnixNano()-t0)/1000000)
-	return nil
+	return encoder, nil
 }
 
-func (raptorq *RaptorQImpl) getChunkSize(msg []byte, chunkID int) int {
-	a := chunkID * normalChunkSize
-	b := (chunkID + 1) * normalChunkSize
-	if chunkID == raptorq.numChunks-1 {
-		b = len(msg)
+// releaseEncoder frees the encoder of a chunk which is no longer broadcast
+func (raptorq *RaptorQImpl) releaseEncoder(chunkID int) {
+	raptorq.mux.Lock()
+	defer raptorq.mux.Unlock()
+	if encoder, ok := raptorq.Encoder[chunkID]; ok {
+		encoder.Close()
+		delete(raptorq.Encoder, chunkID)
 	}
-	return b - a
 }
 
-func (raptorq *RaptorQImpl) constructCommonOTI(transferLength uint64) uint64 {
-	// CommonOTI = |Transfer Length (5)|Reserved(1)|Symbol Size(2)| 8 bytes
-	commonOTI := make([]byte, 0)
-
-	transferLengthBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(transferLengthBytes, transferLength)
-	commonOTI = append(commonOTI, transferLengthBytes[3:8]...)
-	commonOTI = append(commonOTI, byte(0))
-
-	symbolSizeBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(symbolSizeBytes, uint16(symbolSize))
-	commonOTI = append(commonOTI, symbolSizeBytes...)
-
-	return binary.BigEndian.Uint64(commonOTI)
-}
-
-func (raptorq *RaptorQImpl) constructSpecificOTI() uint32 {
-	// SpecificOTI = |Z(1)|N(2)|Al(1)| 4 bytes
-	specificOTI := make([]byte, 0)
-	specificOTI = append(specificOTI, byte(1))
-	specificOTI = append(specificOTI, 0x00, 0x01)
-	specificOTI = append(specificOTI, 0x01)
-	return binary.BigEndian.Uint32(specificOTI)
+func (raptorq *RaptorQImpl) getChunkSize(chunkID int) int {
+	a := int64(chunkID) * int64(raptorq.chunkSize)
+	b := a + int64(raptorq.chunkSize)
+	if chunkID == raptorq.numChunks-1 {
+		b = raptorq.size
+	}
+	return int(b - a)
 }
 
-func (raptorq *RaptorQImpl) setDecoderIfNotExist(chunkID int, chunkSize uint64, node *Node) error {
+// setDecoderIfNotExist creates the decoder of a chunk from the OTI carried by its symbol packet,
+// packets whose OTI differs from the first one received for the chunk are rejected
+func (raptorq *RaptorQImpl) setDecoderIfNotExist(chunkID int, packet *wire.SymbolPacket, node *Node) error {
 	raptorq.mux.Lock()
 	defer raptorq.mux.Unlock()
-	if _, ok := raptorq.Decoder[chunkID]; ok {
+	if raptorq.numChunks == 0 {
+		raptorq.numChunks = int(packet.NumChunks)
+	} else if raptorq.numChunks != int(packet.NumChunks) {
+		return fmt.Errorf("number of chunks %v differs from %v of the message", packet.NumChunks, raptorq.numChunks)
+	}
+	if raptorq.chunkSize == 0 {
+		raptorq.chunkSize = int(packet.ChunkSize)
+	} else if raptorq.chunkSize != int(packet.ChunkSize) {
+		return fmt.Errorf("chunk size %v differs from %v of the message", packet.ChunkSize, raptorq.chunkSize)
+	}
+	o := oti{common: packet.CommonOTI, schemeSpecific: packet.SchemeSpecificOTI}
+	if expected, ok := raptorq.otis[chunkID]; ok {
+		if o != expected {
+			return fmt.Errorf("OTI %+v differs from %+v received first", o, expected)
+		}
+		if len(packet.Symbol) != int(o.symbolSize()) {
+			return fmt.Errorf("symbol of %v bytes, OTI symbol size is %v", len(packet.Symbol), o.symbolSize())
+		}
 		return nil
 	}
+	if raptorq.state != sessionReceiving {
+		return fmt.Errorf("message %x is released", raptorq.rootHash)
+	}
+	if err := o.validate(packet, chunkID == raptorq.numChunks-1); err != nil {
+		return err
+	}
 	decf := raptorfactory.DefaultDecoderFactory()
-	commonOTI := raptorq.constructCommonOTI(chunkSize)
-	specificOTI := raptorq.constructSpecificOTI()
-	log.Printf("DEBUG******: commonOTI: %v, specific: %v", commonOTI, specificOTI)
-
-	decoder, err := decf.New(commonOTI, specificOTI)
-	if err == nil {
-		raptorq.Decoder[chunkID] = decoder
-	} else {
+	log.Printf("decoder common OTI: %v, specific OTI: %v", o.common, o.schemeSpecific)
+	decoder, err := decf.New(o.common, o.schemeSpecific)
+	if err != nil {
 		return err
 	}
-	ready := make(chan uint8)
+	raptorq.Decoder[chunkID] = decoder
+	raptorq.otis[chunkID] = o
+	ready := make(chan uint8, 1)
 	raptorq.Decoder[chunkID].AddReadyBlockChan(ready)
-	go node.handleDecodeSuccess(raptorq.rootHash, chunkID, ready)
+	key := SessionKey{Sender: raptorq.sender, Root: convertToFixedSize(raptorq.rootHash)}
+	node.goroutine(func() { node.handleDecodeSuccess(node.context(), key, raptorq, chunkID, ready) })
 	return nil
 }
 
+// hasChunk reports whether the decoder of a chunk has been created
+func (raptorq *RaptorQImpl) hasChunk(chunkID int) bool {
+	raptorq.mux.Lock()
+	defer raptorq.mux.Unlock()
+	_, ok := raptorq.otis[chunkID]
+	return ok
+}
+
 func expBackoffDelay(initialDelayTime float64, maxDelayTime float64, expBase float64) func(int, int) time.Duration {
 	// delay time unit is milliseconds
 	maxK := math.Log2(maxDelayTime/initialDelayTime) / math.Log2(expBase) //result cap by maxDelayTime
@@ -305,283 +333,535 @@ func expBackoffDelay(initialDelayTime float64, maxDelayTime float64, expBase flo
 	}
 }
 
-func (node *Node) broadCastEncodedSymbol(ctx context.Context, msg []byte, raptorq *RaptorQImpl, pc net.PacketConn, chunkID int) {
+// broadCastEncodedSymbol sends the symbols of a chunk until ctx is done, it returns ErrUploadCapExceeded once the
+// message used its upload budget
+func (node *Node) broadCastEncodedSymbol(ctx context.Context, raptorq *RaptorQImpl, pc net.PacketConn, chunkID int) error {
 	var symbolID uint32
-	peerList := node.PeerList
-	var bytesSent int
-	backoff := expBackoffDelay(node.InitialDelayTime, node.MaxDelayTime, node.ExpBase)
-	err := raptorq.setEncoderIfNotExist(msg, chunkID)
+	peerAddrs := node.sendAddrs()
+	conn := udpbatch.NewConn(pc)
+	batch := make([]udpbatch.Message, 0, sendBatchSize)
+	pacer := raptorq.pacer
+	key := SessionKey{Sender: raptorq.sender, Root: convertToFixedSize(raptorq.rootHash)}
+	encoder, err := raptorq.setEncoderIfNotExist(chunkID)
 	if err != nil {
-		log.Printf("unable to create encoder for chunkID=%v", chunkID)
+		log.Printf("unable to create encoder for chunkID=%v: %v", chunkID, err)
+		return nil
 	}
-	k0 := int(raptorq.Encoder[chunkID].MinSymbols(0))
+	defer raptorq.releaseEncoder(chunkID)
+	defer pacer.forget(chunkID)
+	k0 := int(encoder.MinSymbols(0))
+	// symbol n is due when the pacer of the broadcast schedules it and a token of MaxRate is left,
+	// the symbols due after a sleep are sent in one batch so that the timer granularity does not
+	// cap the symbol rate
+	next := pacer.next(time.Now(), 0, k0)
 	for {
-		select {
-		case <-ctx.Done():
+		wake := next
+		if ready := pacer.ready(time.Now()); ready.After(wake) {
+			wake = ready
+		}
+		if !sleepContext(ctx, time.Until(wake)) {
 			log.Printf("chunkID=%v broadcast stopped", chunkID)
-			return
-		default:
-			k := int(symbolID)
-			time.Sleep(backoff(k, k0))
-
-			packet, err := raptorq.constructSymbolPacket(msg, chunkID, symbolID, node.Hop)
+			return nil
+		}
+		batch = batch[:0]
+		now := time.Now()
+		if now.Sub(next) > maxSendLag {
+			// do not burst to catch up after a stall
+			next = now.Add(-maxSendLag)
+		}
+		for len(batch) < sendBatchSize && !next.After(now) && pacer.take(chunkID, symbolID, now) {
+			symbolPacket, err := raptorq.constructSymbolPacket(encoder, chunkID, symbolID, node.Hop)
 			if err != nil {
 				log.Printf("raptorq encoding error: %s", err)
-				return //chao: return or continue
+				return nil //chao: return or continue
 			}
-			idx := int(symbolID) % len(peerList)
-			remoteAddr := net.JoinHostPort(peerList[idx].IP, peerList[idx].UDPPort)
-			addr, err := net.ResolveUDPAddr("udp", remoteAddr)
+			packet, err := node.signPacket(symbolPacket)
 			if err != nil {
-				log.Printf("cannot resolve udp address %v", remoteAddr)
+				log.Printf("cannot sign symbol packet: %s", err)
+				return nil
 			}
-			bytesSent, err = pc.WriteTo(packet, addr)
-			if err != nil {
-				log.Printf("broadcast encoded symbol written error %v with %v symbol written", err, bytesSent)
-			}
-			if err == nil && bytesSent < len(packet) {
-				log.Printf("udp write with only %v bytes, with original %v bytes", bytesSent, len(packet))
+			idx := int(symbolID) % len(peerAddrs)
+			if peerAddrs[idx] != nil {
+				batch = append(batch, udpbatch.Message{Buf: packet, Addr: peerAddrs[idx]})
 			}
 			if symbolID%100 == 0 {
-				log.Printf("chunkID=%v,  symbolID=%v sent to %v", chunkID, symbolID, remoteAddr)
+				log.Printf("chunkID=%v,  symbolID=%v sent to %v", chunkID, symbolID, peerAddrs[idx])
 			}
+			symbolID++
+			next = pacer.next(next, int(symbolID), k0)
 		}
-		symbolID++
-	}
-}
-
-func (node *Node) relayEncodedSymbol(pc net.PacketConn, packet []byte) {
-	hop := packet[hashSize]
-	if hop == 0 {
-		return
-	}
-	packet[hashSize] = packet[hashSize] - 1
-
-	idx0 := rand.Intn(len(node.PeerList))
-	for i := range node.PeerList {
-		idx := (i + idx0) % len(node.PeerList)
-		peer := node.PeerList[idx]
-		remoteAddr := net.JoinHostPort(peer.IP, peer.UDPPort)
-		addr, err := net.ResolveUDPAddr("udp", remoteAddr)
-		if err != nil {
-			log.Printf("cannot resolve udp address %v", remoteAddr)
+		if len(batch) == 0 {
+			continue
 		}
-		time.Sleep(time.Duration(node.RelayTime * 1000000))
-		n, err := pc.WriteTo(packet, addr)
-		if err != nil {
-			log.Printf("relay symbol failed at %v with %v bytes written", addr, n)
+		size := 0
+		for _, msg := range batch {
+			size += len(msg.Buf)
+		}
+		if !node.admitUpload(key, size) {
+			log.Printf("chunkID=%v broadcast stopped, message used its upload budget", chunkID)
+			return ErrUploadCapExceeded
 		}
-		if err == nil && n < len(packet) {
-			log.Printf("relay symbol write only %v bytes, need write %v bytes", n, len(packet))
+		if !node.throttleUpload(ctx, size) {
+			log.Printf("chunkID=%v broadcast stopped", chunkID)
+			return nil
+		}
+		if n, err := conn.WriteBatch(batch); err != nil {
+			log.Printf("broadcast encoded symbol written error %v with %v of %v symbols written", err, n, len(batch))
 		}
 	}
 }
 
-// Gossip responsible to receive, decode and relay message
-func (node *Node) Gossip(pc net.PacketConn) {
-	buffer := make([]byte, udpCacheSize)
-	for {
-		n, addr, err := pc.ReadFrom(buffer)
+// relayEncodedSymbols relays a batch of received symbols to the peers chosen by the fanout policy, one peer after
+// the other as scheduled by the relay pacer and the upload budget
+func (node *Node) relayEncodedSymbols(ctx context.Context, conn *udpbatch.Conn, symbolPackets []*wire.SymbolPacket) {
+	sources := make([]*wire.SymbolPacket, 0, len(symbolPackets))
+	packets := make([][]byte, 0, len(symbolPackets))
+	keys := make([]SessionKey, 0, len(symbolPackets))
+	for _, symbolPacket := range symbolPackets {
+		relayed := *symbolPacket
+		relayed.Hop--
+		packet, err := relayed.MarshalBinary()
 		if err != nil {
-			log.Printf("gossip receive response from peer %v with error %s", addr, err)
+			log.Printf("cannot encode relayed symbol: %v", err)
 			continue
 		}
-		if n < hashSize+19+symbolSize {
-			log.Printf("gossip received only %v symbols, need %v symbols", n, hashSize+19+symbolSize)
-		}
-		copybuffer := make([]byte, n)
-		copy(copybuffer, buffer[:n])
+		sources = append(sources, symbolPacket)
+		packets = append(packets, packet)
+		keys = append(keys, SessionKey{Sender: peerIDOf(symbolPacket.Sender), Root: convertToFixedSize(symbolPacket.RootHash)})
+	}
+	if len(packets) == 0 {
+		return
+	}
 
-		hash := copybuffer[0:hashSize]
-		hashkey := convertToFixedSize(hash)
-		// not gossip its own message
-		if node.SenderCache[hashkey] {
-			continue
+	batch := make([]udpbatch.Message, 0, len(packets))
+	rehopped := make(map[[2]int][]byte) // symbols relayed with the hops of a relay tree
+	due, n := time.Now(), 0
+	for _, target := range node.fanout(sources) {
+		due = node.relayPacer.Next(due, n, 0)
+		n++
+		if !sleepContext(ctx, time.Until(due)) {
+			return
 		}
-		raptorq := node.initRaptorQIfNotExist(hash)
-		raptorq.senderID = int(binary.BigEndian.Uint16(copybuffer[hashSize+1 : hashSize+3]))
-		raptorq.numChunks = int(binary.BigEndian.Uint32(copybuffer[hashSize+3 : hashSize+7]))
-
-		chunkID := int(binary.BigEndian.Uint32(copybuffer[hashSize+7 : hashSize+11]))
-		chunkSizeBytes := append(make([]byte, 4), copybuffer[hashSize+11:hashSize+15]...)
-		chunkSize := binary.BigEndian.Uint64(chunkSizeBytes)
-		symbolID := binary.BigEndian.Uint32(copybuffer[hashSize+15 : hashSize+19])
-		symbol := copybuffer[hashSize+19 : n]
-		symDebug("received", chunkID, symbolID, symbol)
-		err = raptorq.setDecoderIfNotExist(chunkID, chunkSize, node)
-		if err != nil {
-			log.Printf("unable to set decoder for chunkID=%v, with chunkSize=%v", chunkID, chunkSize)
+		batch = batch[:0]
+		size := 0
+		for _, j := range target.packets {
+			packet := packets[j]
+			if target.hop >= 0 && target.hop != int(sources[j].Hop)-1 {
+				if packet = rehopped[[2]int{j, target.hop}]; packet == nil {
+					relayed := *sources[j]
+					relayed.Hop = uint8(target.hop)
+					encoded, err := relayed.MarshalBinary()
+					if err != nil {
+						continue
+					}
+					packet = encoded
+					rehopped[[2]int{j, target.hop}] = packet
+				}
+			}
+			if node.admitUpload(keys[j], len(packet)) {
+				batch = append(batch, udpbatch.Message{Buf: packet, Addr: target.addr})
+				size += len(packet)
+			}
+		}
+		if len(batch) == 0 {
 			continue
 		}
-
-		if _, ok := raptorq.receivedSymbols[chunkID]; !ok {
-			raptorq.receivedSymbols[chunkID] = make(map[uint32]bool)
+		if !node.throttleUpload(ctx, size) {
+			return
 		}
-
-		// just relay once
-		if raptorq.receivedSymbols[chunkID][symbolID] {
-			continue
+		if n, err := conn.WriteBatch(batch); err != nil {
+			log.Printf("relay symbol failed at %v with %v of %v symbols written: %v", target.addr, n, len(batch), err)
 		}
-		raptorq.receivedSymbols[chunkID][symbolID] = true
+	}
+}
 
-		if !raptorq.Decoder[chunkID].IsSourceObjectReady() {
-			raptorq.Decoder[chunkID].Decode(0, symbolID, symbol)
-			log.Printf("decode symbol %v", symbolID)
+// handleSymbol authenticates a symbol packet, feeds it to the decoder of its chunk and relays it.
+// Packets of a chunk are handled by a single worker, so only this worker creates the decoder of the chunk
+func (node *Node) handleSymbol(packet *wire.SymbolPacket, addr net.Addr) {
+	hash := packet.RootHash
+	key := SessionKey{Sender: peerIDOf(packet.Sender), Root: convertToFixedSize(hash)}
+	chunkID := int(packet.ChunkID)
+	symbolID := packet.SymbolID
+	symbol := packet.Symbol
+	numChunks := int(packet.NumChunks)
+	// not gossip its own message nor rejected or already evicted ones
+	node.mux.Lock()
+	own := node.SenderCache[key.Root]
+	existing := node.Cache[key]
+	node.mux.Unlock()
+	if own || node.isRejected(key) || node.isFinished(key) {
+		return
+	}
+	// the signature is only checked for the symbols the node uses and relays
+	if existing != nil && existing.seen(chunkID, symbolID) {
+		node.accountDownload(key, packet.Size())
+		atomic.AddUint64(&node.cacheStats.DuplicateSymbols, 1)
+		return
+	}
+	if !node.verifyPacket(packet) {
+		log.Printf("gossip dropped unauthenticated packet from %v, blacklisting it", addr)
+		node.blacklistRelay(addr.String())
+		return
+	}
+	hashType := HashType(packet.HashType)
+	if !hashType.Valid() {
+		log.Printf("gossip dropped packet with unknown hash type %v", hashType)
+		return
+	}
+	node.accountDownload(key, packet.Size())
+	if !hashType.verifyMerkleProof(hash, packet.ChunkHash, chunkID, numChunks, packet.Proof) {
+		log.Printf("gossip dropped packet with invalid merkle proof for chunkID=%v", chunkID)
+		return
+	}
+
+	raptorq := node.initRaptorQIfNotExist(hashType, key)
+	if raptorq == nil {
+		log.Printf("gossip dropped packet, no room for message %x", hash)
+		return
+	}
+	if raptorq.hashType != hashType {
+		log.Printf("gossip dropped packet with hash type %v, message uses %v", hashType, raptorq.hashType)
+		return
+	}
+	symDebug("received", chunkID, symbolID, symbol)
+	// the decoder memory is charged before it is created
+	var cost int64
+	if !raptorq.hasChunk(chunkID) {
+		cost = chunkMemory(oti{common: packet.CommonOTI, schemeSpecific: packet.SchemeSpecificOTI})
+		if err := node.reserve(key, raptorq, cost); err != nil {
+			log.Printf("unable to set decoder for chunkID=%v of message %x: %v", chunkID, hash, err)
+			return
 		}
-		go node.relayEncodedSymbol(pc, copybuffer[:n])
 	}
+	err := raptorq.setDecoderIfNotExist(chunkID, packet, node)
+	if err != nil {
+		node.refund(key, raptorq, cost)
+		log.Printf("unable to set decoder for chunkID=%v from %v: %v", chunkID, addr, err)
+		return
+	}
+
+	if !raptorq.recordChunkHash(chunkID, packet.ChunkHash) {
+		log.Printf("chunkID=%v hash from %v differs from the first one received", chunkID, addr)
+		return
+	}
+
+	// just relay once
+	received, ok := raptorq.receive(chunkID, symbolID, symbol)
+	if !ok {
+		atomic.AddUint64(&node.cacheStats.DuplicateSymbols, 1)
+		return
+	}
+	node.relay(packet, received)
+}
+
+// chunk returns the state of a chunk, caller must hold raptorq.mux
+func (raptorq *RaptorQImpl) chunk(chunkID int) *chunkState {
+	chunk := raptorq.chunks[chunkID]
+	if chunk == nil {
+		chunk = &chunkState{}
+		raptorq.chunks[chunkID] = chunk
+	}
+	return chunk
+}
+
+// closeDecoder releases the decoder of a chunk, caller must hold raptorq.mux
+func (raptorq *RaptorQImpl) closeDecoder(chunkID int) {
+	decoder, ok := raptorq.Decoder[chunkID]
+	if !ok {
+		return
+	}
+	chunk := raptorq.chunk(chunkID)
+	chunk.mux.Lock()
+	chunk.closed = true
+	decoder.Close()
+	chunk.mux.Unlock()
+	delete(raptorq.Decoder, chunkID)
+}
+
+// seen reports whether a symbol of a chunk was received before
+func (raptorq *RaptorQImpl) seen(chunkID int, symbolID uint32) bool {
+	raptorq.mux.Lock()
+	chunk := raptorq.chunks[chunkID]
+	raptorq.mux.Unlock()
+	if chunk == nil {
+		return false
+	}
+	chunk.mux.Lock()
+	defer chunk.mux.Unlock()
+	return chunk.symbols.test(symbolID)
+}
+
+// receive records a symbol and feeds it to the decoder of its chunk, it returns the number of symbols of the chunk
+// received before and false if the symbol was received before
+func (raptorq *RaptorQImpl) receive(chunkID int, symbolID uint32, symbol []byte) (uint32, bool) {
+	raptorq.mux.Lock()
+	chunk := raptorq.chunk(chunkID)
+	decoder := raptorq.Decoder[chunkID]
+	if raptorq.state != sessionReceiving {
+		decoder = nil
+	}
+	raptorq.mux.Unlock()
+
+	chunk.mux.Lock()
+	defer chunk.mux.Unlock()
+	if chunk.symbols.testAndSet(symbolID) {
+		return chunk.received, false
+	}
+	received := chunk.received
+	chunk.received++
+	if symbolID > chunk.maxSymbolID {
+		chunk.maxSymbolID = symbolID
+	}
+	atomic.StoreInt64(&raptorq.lastActive, time.Now().UnixNano())
+	// the decoder of a chunk written to a stream is already released
+	if decoder != nil && !chunk.closed && !decoder.IsSourceObjectReady() {
+		decoder.Decode(0, symbolID, symbol)
+		log.Printf("decode symbol %v", symbolID)
+	}
+	return received, true
 }
 
-func (node *Node) handleDecodeSuccess(hash []byte, chunkID int, ch chan uint8) {
-	sbn, ok := <-ch
+func (node *Node) handleDecodeSuccess(ctx context.Context, key SessionKey, raptorq *RaptorQImpl, chunkID int, ch chan uint8) {
+	var sbn uint8
+	var ok bool
+	select {
+	case <-ctx.Done():
+		return
+	case <-raptorq.done:
+		// the message has been rejected or evicted before the chunk was decoded
+		return
+	case sbn, ok = <-ch:
+	}
 	log.Printf("ready channel returned sbn=%+v ok=%+v", sbn, ok)
-	hashkey := convertToFixedSize(hash)
-	node.mux.Lock()
-	defer node.mux.Unlock()
-	raptorq := node.Cache[hashkey]
 	raptorq.mux.Lock()
-	defer raptorq.mux.Unlock()
-	raptorq.numDecoded++
-	numDecoded := raptorq.numDecoded
-	go node.responseSuccess(hash, chunkID)
+	decoder, ok := raptorq.Decoder[chunkID]
+	if !ok || raptorq.state != sessionReceiving {
+		// released meanwhile
+		raptorq.mux.Unlock()
+		return
+	}
 	log.Printf("source object is ready for block %v", chunkID)
-	F := raptorq.Decoder[chunkID].TransferLength()
+	F := decoder.TransferLength()
 	buf := make([]byte, F)
-	raptorq.Decoder[chunkID].SourceObject(buf)
-	log.Printf("sha1 hash for block %v is %v", chunkID, getRootHash(buf))
-	if numDecoded >= raptorq.numChunks {
-		raptorq.successTime = time.Now().UnixNano()
-		writeReceivedMessage(raptorq)
-		//	delete(node.Cache, hashkey) // release resources after receive the file
+	chunkState := raptorq.chunk(chunkID)
+	chunkState.mux.Lock()
+	decoder.SourceObject(buf)
+	chunkState.mux.Unlock()
+	if !bytes.Equal(raptorq.hashType.chunkHash(buf), raptorq.chunkHashes[chunkID]) {
+		corrupt := &CorruptObjectError{RootHash: raptorq.rootHash, Sender: raptorq.sender, ChunkID: chunkID}
+		raptorq.mux.Unlock()
+		node.rejectObject(key, corrupt)
+		return
 	}
+	chunk := Chunk{Data: buf, ChunkID: chunkID, NumChunks: raptorq.numChunks, Offset: int64(chunkID) * int64(raptorq.chunkSize), RootHash: raptorq.rootHash, Sender: raptorq.sender, SenderID: node.sid(raptorq.sender)}
+	if raptorq.open != nil {
+		node.streamChunk(ctx, key, raptorq, chunk)
+		return
+	}
+	raptorq.numDecoded++
+	numDecoded := raptorq.numDecoded
+	if numDecoded < raptorq.numChunks {
+		raptorq.mux.Unlock()
+		node.goroutine(func() { node.responseSuccess(ctx, key, chunkID) })
+		node.deliverChunk(chunk)
+		return
+	}
+	raptorq.state = sessionDelivered
+	atomic.StoreInt64(&raptorq.successTime, time.Now().UnixNano())
+	payload, err := raptorq.reassemble()
+	msg := Message{Payload: payload, Size: int64(len(payload)), RootHash: raptorq.rootHash, Sender: raptorq.sender, SenderID: node.sid(raptorq.sender), InitTime: raptorq.initTime, SuccessTime: raptorq.successTime}
+	var corrupt *CorruptObjectError
+	if err == nil && !bytes.Equal(raptorq.merkleRoot(payload), raptorq.rootHash) {
+		corrupt = &CorruptObjectError{RootHash: raptorq.rootHash, Sender: raptorq.sender, ChunkID: -1}
+	}
+	raptorq.mux.Unlock()
+	node.deliverChunk(chunk)
+	if err != nil {
+		log.Printf("unable to reassemble message %x: %v", key.Root, err)
+		return
+	}
+	if corrupt != nil {
+		node.rejectObject(key, corrupt)
+		return
+	}
+	node.goroutine(func() { node.responseSuccess(ctx, key, chunkID) })
+	// the symbols still relayed for the message are not decoded anymore
+	node.releaseDecoders(key, raptorq)
+	node.deliver(msg)
 }
 
-func (node *Node) initRaptorQIfNotExist(hash []byte) *RaptorQImpl {
-	hashkey := convertToFixedSize(hash)
+// initRaptorQIfNotExist returns the session of a message, a new session evicts another one if the cache
+// holds MaxSessions sessions already. It returns nil if no room can be made
+func (node *Node) initRaptorQIfNotExist(hashType HashType, key SessionKey) *RaptorQImpl {
+	var evicted []*RaptorQImpl
+	defer func() {
+		for _, raptorq := range evicted {
+			raptorq.release()
+		}
+	}()
 	node.mux.Lock()
 	defer node.mux.Unlock()
-	if node.Cache[hashkey] == nil {
-		log.Printf("raptorq initialized with hash %v", hashkey)
+	if node.Cache[key] == nil {
+		var ok bool
+		if evicted, ok = node.makeRoom(); !ok {
+			return nil
+		}
+		log.Printf("raptorq initialized with hash %x from sender %v", key.Root, key.Sender)
 		raptorq := RaptorQImpl{}
 		raptorq.threshold = int(threshold * float32(len(node.AllPeers)))
-		raptorq.rootHash = hash
-		raptorq.chunkSize = normalChunkSize
-		raptorq.receivedSymbols = make(map[int]map[uint32]bool)
+		raptorq.hashType = hashType
+		raptorq.rootHash = append([]byte(nil), key.Root[:]...)
+		raptorq.sender = key.Sender
+		raptorq.chunks = make(map[int]*chunkState)
+		raptorq.done = make(chan struct{})
+		raptorq.chunkHashes = make(map[int][]byte)
+		raptorq.otis = make(map[int]oti)
+		raptorq.open = node.opener
 		raptorq.initTime = time.Now().UnixNano()
+		raptorq.lastActive = raptorq.initTime
 		raptorq.Decoder = make(map[int]libraptorq.Decoder)
-		node.Cache[hashkey] = &raptorq
+		node.Cache[key] = &raptorq
 	}
-	return node.Cache[hashkey]
+	return node.Cache[key]
 }
 
 func (node *Node) handleResponse(conn net.Conn) {
 	defer conn.Close()
-	c := bufio.NewReader(conn)
-	hash := make([]byte, hashSize)
-	n, err := io.ReadFull(c, hash)
+	conn.SetReadDeadline(time.Now().Add(responseReadTimeout * time.Second))
+	buf := make([]byte, wire.AckPacketSize)
+	n, err := io.ReadFull(conn, buf)
 	if err != nil {
 		log.Printf("response received %v size message with err %v", n, err)
 		return
 	}
-	hashkey := convertToFixedSize(hash)
+	var ack wire.AckPacket
+	if err := ack.UnmarshalBinary(buf); err != nil {
+		log.Printf("tcp received malformed response: %v", err)
+		return
+	}
+	hashkey := convertToFixedSize(ack.RootHash)
 	//message is not sent by the node
-	if _, ok := node.SenderCache[hashkey]; !ok {
+	node.mux.Lock()
+	_, ok := node.SenderCache[hashkey]
+	node.mux.Unlock()
+	if !ok {
 		return
 	}
-	mtype, _ := c.ReadByte()
-	switch mtype {
-	case metaReceived:
-		chunkIDBytes := make([]byte, 4)
-		_, err := io.ReadFull(c, chunkIDBytes)
-		chunkID := int(binary.BigEndian.Uint32(chunkIDBytes))
-		node.mux.Lock()
-		if _, ok := node.PeerDecodedCounter[hashkey]; !ok {
-			node.PeerDecodedCounter[hashkey] = make(map[int]int)
-		}
-		node.PeerDecodedCounter[hashkey][chunkID] = node.PeerDecodedCounter[hashkey][chunkID] + 1
-		node.mux.Unlock()
-		sid := make([]byte, 4)
-		_, err = io.ReadFull(c, sid)
-		if err != nil {
-			log.Printf("node sid read error")
-		}
-		log.Printf("chunkID=%v decoded confirmation received from %v", chunkID, binary.BigEndian.Uint32(sid))
+	if !node.verifyAck(&ack) {
+		log.Printf("tcp received unauthenticated response from %v", conn.RemoteAddr())
 		return
-	default:
-		log.Printf("tcp received unknown data type")
 	}
+	chunkID := int(ack.ChunkID)
+	peer := peerIDOf(ack.Peer)
+	node.mux.Lock()
+	pacer := node.pacers[hashkey]
+	if _, ok := node.PeerDecoded[hashkey]; !ok {
+		node.PeerDecoded[hashkey] = make(map[int]map[PeerID]bool)
+	}
+	if _, ok := node.PeerDecoded[hashkey][chunkID]; !ok {
+		node.PeerDecoded[hashkey][chunkID] = make(map[PeerID]bool)
+	}
+	duplicate := node.PeerDecoded[hashkey][chunkID][peer]
+	node.PeerDecoded[hashkey][chunkID][peer] = true
+	node.mux.Unlock()
+	if duplicate {
+		atomic.AddUint64(&node.authStats.DuplicateAcks, 1)
+		return
+	}
+	atomic.AddUint64(&node.authStats.Acks, 1)
+	log.Printf("chunkID=%v decoded confirmation received from %v", chunkID, node.sid(peer))
+	if pacer != nil {
+		pacer.onAck(chunkID, ack.Received, ack.MaxSymbolID, time.Now())
+	}
+}
+
+// decodedPeers returns the number of distinct verified peers which acknowledged a chunk of a message sent by the node
+func (node *Node) decodedPeers(hashkey HashKey, chunkID int) int {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	return len(node.PeerDecoded[hashkey][chunkID])
 }
 
 // this is used for stop sender, will be replaced by consensus algorithm later
-func (node *Node) responseSuccess(hash []byte, chunkID int) {
-	// |hash(20)|Received(1)|chunkID(4)|peerId(4)|
-	okmsg := make([]byte, 0)
-	okmsg = append(okmsg, hash...)
-	okmsg = append(okmsg, metaReceived)
-	chunkIDBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(chunkIDBytes, uint32(chunkID))
-	okmsg = append(okmsg, chunkIDBytes...)
-	sid := make([]byte, 4)
-	binary.BigEndian.PutUint32(sid, uint32(node.SelfPeer.Sid))
-	okmsg = append(okmsg, sid...)
-	hashkey := convertToFixedSize(hash)
+func (node *Node) responseSuccess(ctx context.Context, key SessionKey, chunkID int) {
 	node.mux.Lock()
-	raptorq := node.Cache[hashkey]
+	raptorq := node.Cache[key]
 	node.mux.Unlock()
-	for _, peer := range node.AllPeers {
-		if peer.Sid != raptorq.senderID {
-			continue
+	if raptorq == nil {
+		return
+	}
+	peer, ok := node.peers[key.Sender]
+	if !ok {
+		log.Printf("no address known for sender %v, chunkID=%v not acknowledged", key.Sender, chunkID)
+		return
+	}
+	ack := wire.AckPacket{HashType: byte(raptorq.hashType), RootHash: key.Root[:], ChunkID: uint32(chunkID), Peer: node.selfID[:]}
+	raptorq.mux.Lock()
+	chunk := raptorq.chunk(chunkID)
+	chunk.mux.Lock()
+	ack.Received, ack.MaxSymbolID = chunk.received, chunk.maxSymbolID
+	chunk.mux.Unlock()
+	raptorq.mux.Unlock()
+	okmsg, err := node.signAck(&ack)
+	if err != nil {
+		log.Printf("cannot encode response for chunkID=%v: %v", chunkID, err)
+		return
+	}
+	if node.Fanout.Policy == FanoutUnfinished {
+		node.announce(okmsg)
+	}
+	tcpaddr := net.JoinHostPort(peer.IP, peer.TCPPort)
+	conn, err := node.Transport.Dial(ctx, tcpaddr)
+	if err != nil {
+		log.Printf("dial to tcp addr %v failed with %v", tcpaddr, err)
+		backoff := expBackoffDelay(1000, 15000, 1.35)
+		for i := 0; i < 10; i++ {
+			if !sleepContext(ctx, backoff(i, 0)) {
+				return
+			}
+			conn, err = node.Transport.Dial(ctx, tcpaddr)
+			if err == nil {
+				break
+			}
+			log.Printf("dial to tcp addr %v failed with %v (retry %v)", tcpaddr, err, i)
 		}
-		tcpaddr := net.JoinHostPort(peer.IP, peer.TCPPort)
-		conn, err := net.Dial("tcp", tcpaddr)
 		if err != nil {
-			log.Printf("dial to tcp addr %v failed with %v", tcpaddr, err)
-			backoff := expBackoffDelay(1000, 15000, 1.35)
-			for i := 0; i < 10; i++ {
-				time.Sleep(backoff(i, 0))
-				conn, err = net.Dial("tcp", tcpaddr)
-				if err == nil {
-					break
-				}
-				log.Printf("dial to tcp addr %v failed with %v (retry %v)", tcpaddr, err, i)
-			}
-			log.Printf("retry exhausted")
+			log.Printf("retry exhausted, chunkID=%v not acknowledged to %v", chunkID, tcpaddr)
+			return
 		}
-		if err == nil && conn != nil {
-			_, err = conn.Write(okmsg)
-			log.Printf("node %v send okay message for chunkID=%v to sender %v", node.SelfPeer.Sid, chunkID, tcpaddr)
-			if err != nil {
-				log.Printf("send received message to sender %v failed with %v", tcpaddr, err)
-			}
+	}
+	if conn != nil {
+		defer conn.Close()
+		_, err = conn.Write(okmsg)
+		log.Printf("node %v send okay message for chunkID=%v to sender %v", node.SelfPeer.Sid, chunkID, tcpaddr)
+		if err != nil {
+			log.Printf("send received message to sender %v failed with %v", tcpaddr, err)
 		}
-		return
 	}
 }
 
-func writeReceivedMessage(raptorq *RaptorQImpl) {
+// reassemble concatenates the decoded chunks into the original message, caller must hold raptorq.mux
+func (raptorq *RaptorQImpl) reassemble() ([]byte, error) {
 	if raptorq.numDecoded < raptorq.numChunks {
-		log.Printf("source object is not ready")
-		return
+		return nil, fmt.Errorf("source object is not ready")
 	}
 	var F int
 	for i := 0; i < raptorq.numChunks; i++ {
 		F += int(raptorq.Decoder[i].TransferLength())
 	}
-	log.Printf("writing decoded source file with %v bytes......", F)
+	log.Printf("reassembling decoded source object with %v bytes......", F)
 	buf := make([]byte, F)
 	var offset int
 	for i := 0; i < raptorq.numChunks; i++ {
 		size := int(raptorq.Decoder[i].TransferLength())
+		chunk := raptorq.chunk(i)
+		chunk.mux.Lock()
 		_, err := raptorq.Decoder[i].SourceObject(buf[offset : offset+size])
+		chunk.mux.Unlock()
 		if err != nil {
-			log.Printf("decode object failed at chunkID=%v with chunkSize=%v", i, size)
-			return
+			return nil, fmt.Errorf("decode object failed at chunkID=%v with chunkSize=%v: %v", i, size, err)
 		}
 		offset += size
 	}
-	fileloc := "received/" + strconv.Itoa(raptorq.senderID) + "_" + strconv.FormatUint(uint64(raptorq.successTime), 10)
-	err := ioutil.WriteFile(fileloc, buf, 0644)
-	if err != nil {
-		log.Printf("unable to write file %v to disk", fileloc)
-	}
+	return buf, nil
 }
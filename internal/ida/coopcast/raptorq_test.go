@@ -0,0 +1,75 @@
+package coopcast
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+	"net"
+	"testing"
+)
+
+// testAck returns an acknowledgement of peer signed with key
+func testAck(t testing.TB, root []byte, chunkID uint32, peer ed25519.PublicKey, key ed25519.PrivateKey) []byte {
+	ack := wire.AckPacket{HashType: byte(SHA256), RootHash: root, ChunkID: chunkID, Received: 10, MaxSymbolID: 12, Peer: peer}
+	signed, err := ack.SignedBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack.Signature = ed25519.Sign(key, signed)
+	data, err := ack.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestHandleResponse checks which acknowledgements count towards stopping a broadcast
+func TestHandleResponse(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	node := c.nodes[0]
+	root := bytes.Repeat([]byte{1}, wire.HashSize)
+	other := bytes.Repeat([]byte{2}, wire.HashSize)
+	hashkey := convertToFixedSize(root)
+	node.mux.Lock()
+	node.SenderCache[hashkey] = true
+	node.mux.Unlock()
+
+	public := func(key ed25519.PrivateKey) ed25519.PublicKey { return key.Public().(ed25519.PublicKey) }
+	_, stranger, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		stats   AuthStats
+		decoded int // peers which decoded chunk 0
+	}{
+		{"first ack", testAck(t, root, 0, public(c.keys[1]), c.keys[1]), AuthStats{Acks: 1}, 1},
+		{"duplicate ack", testAck(t, root, 0, public(c.keys[1]), c.keys[1]), AuthStats{Acks: 1, DuplicateAcks: 1}, 1},
+		{"other chunk", testAck(t, root, 1, public(c.keys[1]), c.keys[1]), AuthStats{Acks: 2, DuplicateAcks: 1}, 1},
+		{"other peer", testAck(t, root, 0, public(c.keys[2]), c.keys[2]), AuthStats{Acks: 3, DuplicateAcks: 1}, 2},
+		{"signed by another peer", testAck(t, root, 2, public(c.keys[2]), c.keys[1]), AuthStats{Acks: 3, DuplicateAcks: 1, BadAcks: 1}, 2},
+		{"unknown peer", testAck(t, root, 2, public(stranger), stranger), AuthStats{Acks: 3, DuplicateAcks: 1, BadAcks: 2}, 2},
+		{"own ack", testAck(t, root, 2, public(c.keys[0]), c.keys[0]), AuthStats{Acks: 3, DuplicateAcks: 1, BadAcks: 3}, 2},
+		{"message not sent by the node", testAck(t, other, 2, public(c.keys[2]), c.keys[2]), AuthStats{Acks: 3, DuplicateAcks: 1, BadAcks: 3}, 2},
+		{"truncated", testAck(t, root, 2, public(c.keys[2]), c.keys[2])[:wire.AckPacketSize-1], AuthStats{Acks: 3, DuplicateAcks: 1, BadAcks: 3}, 2},
+	}
+	for _, test := range tests {
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			node.handleResponse(server)
+		}()
+		client.Write(test.data)
+		client.Close()
+		<-done
+		if stats := node.AuthStats(); stats != test.stats {
+			t.Errorf("%v: stats %+v, want %+v", test.name, stats, test.stats)
+		}
+		if decoded := node.decodedPeers(hashkey, 0); decoded != test.decoded {
+			t.Errorf("%v: %v peers decoded chunk 0, want %v", test.name, decoded, test.decoded)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package coopcast
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+	"sync"
+)
+
+// RelayDropPolicy chooses the symbol dropped when a symbol is queued to a full relay queue
+type RelayDropPolicy int
+
+// drop policies of the relay queue
+const (
+	DropOldest    RelayDropPolicy = iota // the symbol queued first
+	DropDelivered                        // a symbol of the chunk the node received the most symbols of, to favor the under-delivered chunks
+	DropNewest                           // the symbol being queued
+)
+
+func (p RelayDropPolicy) String() string {
+	switch p {
+	case DropOldest:
+		return "oldest"
+	case DropDelivered:
+		return "delivered"
+	case DropNewest:
+		return "newest"
+	}
+	return fmt.Sprintf("RelayDropPolicy(%d)", int(p))
+}
+
+// ParseRelayDropPolicy returns the RelayDropPolicy named s, as printed by RelayDropPolicy.String
+func ParseRelayDropPolicy(s string) (RelayDropPolicy, error) {
+	for _, policy := range []RelayDropPolicy{DropOldest, DropDelivered, DropNewest} {
+		if policy.String() == s {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown relay drop policy %v", s)
+}
+
+// orders of the symbols in the relay queue
+const (
+	byPriority = iota // next symbol relayed, the one of the chunk the node received the fewest symbols of
+	byAge             // oldest symbol first
+	byDelivery        // symbol of the chunk the node received the most symbols of first
+	numRelayOrders
+)
+
+// relayItem is a symbol waiting in the relay queue
+type relayItem struct {
+	packet   *wire.SymbolPacket
+	received uint32 // symbols of the chunk the node received before this one
+	seq      uint64 // queueing order
+	index    [numRelayOrders]int
+}
+
+// relayHeap orders the relay queue, every item is in the heap of every order
+type relayHeap struct {
+	order int
+	items []*relayItem
+}
+
+func (h *relayHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *relayHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	switch h.order {
+	case byAge:
+		return a.seq < b.seq
+	case byDelivery:
+		return a.received > b.received || a.received == b.received && a.seq > b.seq
+	}
+	return a.received < b.received || a.received == b.received && a.seq < b.seq
+}
+
+func (h *relayHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].index[h.order] = i
+	h.items[j].index[h.order] = j
+}
+
+func (h *relayHeap) Push(x interface{}) {
+	item := x.(*relayItem)
+	item.index[h.order] = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *relayHeap) Pop() interface{} {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items[n-1] = nil
+	h.items = h.items[:n-1]
+	return item
+}
+
+// relayQueue is a bounded priority queue of the symbols to relay. The symbols of the chunks the node received the
+// fewest symbols of are relayed first, so that a chunk starting late is not stuck behind the symbols of the others
+type relayQueue struct {
+	policy RelayDropPolicy
+	size   int
+	ready  chan struct{} // a token per queued symbol, so that the relay workers wait for a symbol or their context
+
+	mux   sync.Mutex
+	heaps [numRelayOrders]relayHeap
+	seq   uint64
+	peak  int
+}
+
+func newRelayQueue(size int, policy RelayDropPolicy) *relayQueue {
+	q := &relayQueue{policy: policy, size: size, ready: make(chan struct{}, size)}
+	for order := range q.heaps {
+		q.heaps[order].order = order
+	}
+	return q
+}
+
+// push queues a symbol, received is the number of symbols of its chunk the node received before.
+// It returns false if a symbol, the queued one or another one, was dropped because the queue is full
+func (q *relayQueue) push(packet *wire.SymbolPacket, received uint32) bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	item := &relayItem{packet: packet, received: received, seq: q.seq}
+	q.seq++
+	if q.heaps[byPriority].Len() < q.size {
+		q.add(item)
+		// never blocks, the tokens are at most the queued symbols
+		q.ready <- struct{}{}
+		if n := q.heaps[byPriority].Len(); n > q.peak {
+			q.peak = n
+		}
+		return true
+	}
+	var victim *relayItem
+	switch q.policy {
+	case DropNewest:
+		return false
+	case DropDelivered:
+		victim = q.heaps[byDelivery].items[0]
+		if item.received >= victim.received {
+			return false
+		}
+	default:
+		victim = q.heaps[byAge].items[0]
+	}
+	q.remove(victim)
+	q.add(item)
+	return false
+}
+
+// pop returns the next symbol to relay, the caller must have taken a token of ready
+func (q *relayQueue) pop() *wire.SymbolPacket {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	item := q.heaps[byPriority].items[0]
+	q.remove(item)
+	return item.packet
+}
+
+// add inserts an item in every heap, caller must hold q.mux
+func (q *relayQueue) add(item *relayItem) {
+	for order := range q.heaps {
+		heap.Push(&q.heaps[order], item)
+	}
+}
+
+// remove takes an item out of every heap, caller must hold q.mux
+func (q *relayQueue) remove(item *relayItem) {
+	for order := range q.heaps {
+		heap.Remove(&q.heaps[order], item.index[order])
+	}
+}
+
+// depth returns the symbols in the queue and the most symbols it held at once
+func (q *relayQueue) depth() (int, int) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.heaps[byPriority].Len(), q.peak
+}
@@ -0,0 +1,127 @@
+package coopcast
+
+import (
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+	"reflect"
+	"testing"
+)
+
+// queued is a symbol pushed to a relay queue
+type queued struct {
+	symbolID uint32
+	received uint32
+}
+
+// drain pops every symbol of the queue and returns their IDs in order
+func drain(q *relayQueue) []uint32 {
+	var ids []uint32
+	for len(q.ready) > 0 {
+		<-q.ready
+		ids = append(ids, q.pop().SymbolID)
+	}
+	return ids
+}
+
+// TestRelayQueueOrder checks that the symbols of the chunks the node received the fewest symbols of go first,
+// in the order they were queued
+func TestRelayQueueOrder(t *testing.T) {
+	q := newRelayQueue(10, DropOldest)
+	for _, symbol := range []queued{{0, 5}, {1, 1}, {2, 9}, {3, 1}, {4, 0}, {5, 5}} {
+		if !q.push(&wire.SymbolPacket{SymbolID: symbol.symbolID}, symbol.received) {
+			t.Fatalf("symbol %v dropped from a queue with room", symbol.symbolID)
+		}
+	}
+	if ids := drain(q); !reflect.DeepEqual(ids, []uint32{4, 1, 3, 0, 5, 2}) {
+		t.Errorf("symbols relayed in the order %v, want [4 1 3 0 5 2]", ids)
+	}
+}
+
+// TestRelayQueueDrop checks which symbol every policy drops when the queue is full
+func TestRelayQueueDrop(t *testing.T) {
+	full := []queued{{0, 5}, {1, 1}, {2, 9}}
+	tests := []struct {
+		policy RelayDropPolicy
+		next   queued
+		ids    []uint32 // relayed, in order
+	}{
+		{DropOldest, queued{3, 4}, []uint32{1, 3, 2}},
+		{DropOldest, queued{3, 10}, []uint32{1, 2, 3}},
+		{DropDelivered, queued{3, 4}, []uint32{1, 3, 0}},
+		{DropDelivered, queued{3, 9}, []uint32{1, 0, 2}},
+		{DropDelivered, queued{3, 10}, []uint32{1, 0, 2}},
+		{DropNewest, queued{3, 0}, []uint32{1, 0, 2}},
+	}
+	for _, test := range tests {
+		q := newRelayQueue(len(full), test.policy)
+		for _, symbol := range full {
+			if !q.push(&wire.SymbolPacket{SymbolID: symbol.symbolID}, symbol.received) {
+				t.Fatalf("%v: symbol %v dropped from a queue with room", test.policy, symbol.symbolID)
+			}
+		}
+		if q.push(&wire.SymbolPacket{SymbolID: test.next.symbolID}, test.next.received) {
+			t.Errorf("%v: push() to a full queue reported no drop", test.policy)
+		}
+		if depth, peak := q.depth(); depth != len(full) || peak != len(full) || len(q.ready) != len(full) {
+			t.Errorf("%v: %v symbols, a peak of %v and %v tokens, want %v", test.policy, depth, peak, len(q.ready), len(full))
+		}
+		if ids := drain(q); !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%v: symbol %v of %v received queued, relayed %v, want %v", test.policy, test.next.symbolID, test.next.received, ids, test.ids)
+		}
+	}
+}
+
+// TestRelayQueueBound checks that the queue never holds more than its size and remembers its peak
+func TestRelayQueueBound(t *testing.T) {
+	for _, policy := range []RelayDropPolicy{DropOldest, DropDelivered, DropNewest} {
+		q := newRelayQueue(4, policy)
+		for i := 0; i < 20; i++ {
+			if ok := q.push(&wire.SymbolPacket{SymbolID: uint32(i)}, uint32(20-i)); ok != (i < 4) {
+				t.Errorf("%v: push() of symbol %v = %v", policy, i, ok)
+			}
+			if depth, _ := q.depth(); depth > 4 || len(q.ready) != depth {
+				t.Fatalf("%v: %v symbols and %v tokens in a queue of 4", policy, depth, len(q.ready))
+			}
+		}
+		if ids := drain(q); len(ids) != 4 {
+			t.Errorf("%v: relayed %v, want 4 symbols", policy, ids)
+		}
+		if depth, peak := q.depth(); depth != 0 || peak != 4 {
+			t.Errorf("%v: %v symbols and a peak of %v after the queue was drained, want 0 and 4", policy, depth, peak)
+		}
+	}
+}
+
+// TestRelayDropStats checks the relay counters of PipelineStats
+func TestRelayDropStats(t *testing.T) {
+	c := newTestCluster(t, 2, func(i int, opts *Options) {
+		opts.Pipeline = PipelineOptions{QueueSize: 3, RelayPolicy: DropDelivered}
+	})
+	// the node is not started, no relay worker takes the symbols
+	node := c.nodes[0]
+	for i := 0; i < 5; i++ {
+		node.relay(&wire.SymbolPacket{Hop: 1, SymbolID: uint32(i)}, uint32(i))
+	}
+	node.relay(&wire.SymbolPacket{Hop: 0, SymbolID: 5}, 0)
+	stats := node.PipelineStats()
+	if stats.RelayDrops != 2 || stats.RelayQueue != 3 || stats.RelayPeak != 3 {
+		t.Errorf("stats %+v, want 2 drops and 3 symbols queued at most", stats)
+	}
+	if ids := drain(node.pipeline.relays); !reflect.DeepEqual(ids, []uint32{0, 1, 2}) {
+		t.Errorf("relayed %v, want the symbols of the fewest received [0 1 2]", ids)
+	}
+	if stats := node.PipelineStats(); stats.RelayQueue != 0 || stats.RelayPeak != 3 {
+		t.Errorf("stats %+v after the queue was drained, want none queued and a peak of 3", stats)
+	}
+}
+
+// TestParseRelayDropPolicy checks that every policy parses back from its name
+func TestParseRelayDropPolicy(t *testing.T) {
+	for _, policy := range []RelayDropPolicy{DropOldest, DropDelivered, DropNewest} {
+		if parsed, err := ParseRelayDropPolicy(policy.String()); err != nil || parsed != policy {
+			t.Errorf("ParseRelayDropPolicy(%q) = %v, %v, want %v", policy.String(), parsed, err, policy)
+		}
+	}
+	if _, err := ParseRelayDropPolicy("random"); err == nil {
+		t.Errorf("ParseRelayDropPolicy(random) succeeded")
+	}
+}
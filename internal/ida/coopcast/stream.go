@@ -0,0 +1,140 @@
+package coopcast
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// StreamInfo describes a message whose chunks are about to be written to an io.WriterAt
+type StreamInfo struct {
+	RootHash  []byte
+	Sender    PeerID
+	SenderID  int // Sid of the sender in AllPeers, -1 if the sender is unknown
+	NumChunks int
+	ChunkSize int // size of every chunk but the last one, chunk z is written at offset z*ChunkSize
+	InitTime  int64
+}
+
+// StreamOpener returns the destination of a message being received, it is called when the first chunk is decoded.
+// If the returned io.WriterAt is also an io.Closer it is closed once the message is delivered or discarded.
+type StreamOpener func(info StreamInfo) (io.WriterAt, error)
+
+// OnStream makes the node write every decoded chunk to the io.WriterAt returned by open instead of
+// reassembling messages in memory. The decoder of a chunk is released once the chunk is written, so
+// the memory used by a message is bounded by the chunks being decoded. The MessageHandlers are still
+// called when every chunk has been written, with a nil Payload.
+func (node *Node) OnStream(open StreamOpener) {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.opener = open
+}
+
+// FileStream returns a StreamOpener which writes every message into dir as <senderID>_<initTime>,
+// the hex encoded public key replaces the id of unknown senders
+func FileStream(dir string) StreamOpener {
+	return func(info StreamInfo) (io.WriterAt, error) {
+		sender := strconv.Itoa(info.SenderID)
+		if info.SenderID < 0 {
+			sender = info.Sender.String()
+		}
+		fileloc := filepath.Join(dir, sender+"_"+strconv.FormatUint(uint64(info.InitTime), 10))
+		return os.OpenFile(fileloc, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	}
+}
+
+// streamChunk writes a verified chunk to the stream of the message and delivers the message once every chunk
+// is written. The chunk hashes are checked against the root hash by their merkle proofs, so the message is not
+// read back to verify it. Caller must hold raptorq.mux, it is released.
+func (node *Node) streamChunk(ctx context.Context, key SessionKey, raptorq *RaptorQImpl, chunk Chunk) {
+	chunkID := chunk.ChunkID
+	if err := raptorq.writeChunk(node, chunkID, chunk.Data); err != nil {
+		raptorq.mux.Unlock()
+		log.Printf("discarding message %x: %v", key.Root, err)
+		node.discardObject(key, err)
+		return
+	}
+	raptorq.numDecoded++
+	if raptorq.numDecoded < raptorq.numChunks {
+		raptorq.mux.Unlock()
+		node.refund(key, raptorq, int64(len(chunk.Data)))
+		node.goroutine(func() { node.responseSuccess(ctx, key, chunkID) })
+		node.deliverChunk(chunk)
+		return
+	}
+	raptorq.state = sessionDelivered
+	atomic.StoreInt64(&raptorq.successTime, time.Now().UnixNano())
+	raptorq.closeStream()
+	msg := Message{Size: raptorq.written, RootHash: raptorq.rootHash, Sender: raptorq.sender, SenderID: node.sid(raptorq.sender), InitTime: raptorq.initTime, SuccessTime: raptorq.successTime}
+	raptorq.mux.Unlock()
+	node.goroutine(func() { node.responseSuccess(ctx, key, chunkID) })
+	node.releaseDecoders(key, raptorq)
+	node.deliverChunk(chunk)
+	node.deliver(msg)
+}
+
+// writeChunk writes a decoded chunk to the stream of the message and releases its decoder,
+// the stream is opened on the first chunk, caller must hold raptorq.mux
+func (raptorq *RaptorQImpl) writeChunk(node *Node, chunkID int, buf []byte) error {
+	if raptorq.stream == nil {
+		info := StreamInfo{RootHash: raptorq.rootHash, Sender: raptorq.sender, SenderID: node.sid(raptorq.sender), NumChunks: raptorq.numChunks, ChunkSize: raptorq.chunkSize, InitTime: raptorq.initTime}
+		stream, err := raptorq.open(info)
+		if err != nil {
+			return fmt.Errorf("cannot open stream of message %x: %v", raptorq.rootHash, err)
+		}
+		raptorq.stream = stream
+	}
+	offset := int64(chunkID) * int64(raptorq.chunkSize)
+	if _, err := raptorq.stream.WriteAt(buf, offset); err != nil {
+		return fmt.Errorf("cannot write chunkID=%v of message %x: %v", chunkID, raptorq.rootHash, err)
+	}
+	raptorq.written += int64(len(buf))
+	raptorq.closeDecoder(chunkID)
+	return nil
+}
+
+// release closes the stream and the decoders of a message which is delivered or discarded
+func (raptorq *RaptorQImpl) release() {
+	raptorq.mux.Lock()
+	defer raptorq.mux.Unlock()
+	raptorq.closeStream()
+	for z := range raptorq.Decoder {
+		raptorq.closeDecoder(z)
+	}
+	if raptorq.state == sessionReceiving {
+		raptorq.state = sessionReleased
+	}
+	select {
+	case <-raptorq.done:
+	default:
+		close(raptorq.done)
+	}
+}
+
+// releaseDecoders releases the decoders of a delivered message which stays in the cache
+// to ignore the symbols still relayed for it, and gives back their memory
+func (node *Node) releaseDecoders(key SessionKey, raptorq *RaptorQImpl) {
+	raptorq.release()
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.uncharge(key, raptorq, raptorq.memory)
+}
+
+// closeStream closes the stream of the message if it is an io.Closer, caller must hold raptorq.mux
+func (raptorq *RaptorQImpl) closeStream() {
+	if raptorq.stream == nil {
+		return
+	}
+	if closer, ok := raptorq.stream.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("cannot close stream of message %x: %v", raptorq.rootHash, err)
+		}
+	}
+	raptorq.stream = nil
+}
@@ -0,0 +1,193 @@
+package coopcast
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memWriterAt is an in-memory io.WriterAt and io.Closer
+type memWriterAt struct {
+	mux    sync.Mutex
+	buf    []byte
+	closed bool
+}
+
+func (w *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if need := int(off) + len(p); need > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, need-len(w.buf))...)
+	}
+	copy(w.buf[off:], p)
+	return len(p), nil
+}
+
+func (w *memWriterAt) Close() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.closed = true
+	return nil
+}
+
+func (w *memWriterAt) contents() ([]byte, bool) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return append([]byte(nil), w.buf...), w.closed
+}
+
+// TestBroadCastReaderStream broadcasts a message read from an io.ReaderAt in several chunks, the last one short,
+// and checks that it is written to the io.WriterAt of every receiver byte for byte
+func TestBroadCastReaderStream(t *testing.T) {
+	const numNodes = 3
+	encoding := EncodingParams{SymbolSize: 1200, Alignment: 4, SubBlocks: 1, ChunkSize: 12000}
+	c := newTestCluster(t, numNodes, nil)
+	dir := t.TempDir()
+	sink := &memWriterAt{}
+	var infos []StreamInfo
+	c.nodes[1].OnStream(func(info StreamInfo) (io.WriterAt, error) {
+		infos = append(infos, info)
+		return sink, nil
+	})
+	c.nodes[2].OnStream(FileStream(dir))
+	delivered := make(chan Message, numNodes)
+	for _, node := range c.nodes {
+		node.OnMessage(func(msg Message) { delivered <- msg })
+	}
+	c.start(t)
+	defer c.close()
+
+	msg := make([]byte, 4*int(encoding.ChunkSize)+1000)
+	rand.Read(msg)
+	h, err := c.nodes[0].BroadCastReader(bytes.NewReader(msg), int64(len(msg)), c.conns[0], BroadCastOptions{Encoding: encoding, Window: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	if stats := h.Stats(); stats.NumChunks != 5 || len(stats.FinishedChunks) != 5 {
+		t.Errorf("%v of %v chunks finished, want 5 of 5", len(stats.FinishedChunks), stats.NumChunks)
+	}
+	for i := 1; i < numNodes; i++ {
+		select {
+		case got := <-delivered:
+			if got.Payload != nil || got.Size != int64(len(msg)) {
+				t.Errorf("delivered %v bytes in memory and a size of %v, want none and %v", len(got.Payload), got.Size, len(msg))
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%v of %v nodes delivered the message", i-1, numNodes-1)
+		}
+	}
+
+	if len(infos) != 1 || infos[0].NumChunks != 5 || infos[0].ChunkSize != int(encoding.ChunkSize) || infos[0].SenderID != 0 {
+		t.Errorf("stream opened with %+v, want once for 5 chunks of %v bytes of sender 0", infos, encoding.ChunkSize)
+	}
+	written, closed := sink.contents()
+	if !bytes.Equal(written, msg) {
+		t.Errorf("stream contains %v bytes which differ from the %v bytes broadcast", len(written), len(msg))
+	}
+	if !closed {
+		t.Errorf("stream not closed after the message was delivered")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, strconv.Itoa(0)+"_*"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("FileStream wrote %v, want one file of sender 0", files)
+	}
+	if data, err := ioutil.ReadFile(files[0]); err != nil || !bytes.Equal(data, msg) {
+		t.Errorf("file contains %v bytes which differ from the %v bytes broadcast: %v", len(data), len(msg), err)
+	}
+}
+
+// TestBroadCastReaderEmpty checks that an empty message is refused
+func TestBroadCastReaderEmpty(t *testing.T) {
+	c := newTestCluster(t, 2, nil)
+	if _, err := c.nodes[0].BroadCastReader(bytes.NewReader(nil), 0, nil, BroadCastOptions{}); err != errEmptyMessage {
+		t.Errorf("BroadCastReader() = %v, want %v", err, errEmptyMessage)
+	}
+}
+
+// TestOnChunk checks that every chunk is handed over once with its index and offset, before the whole message,
+// whether the message is reassembled in memory or streamed
+func TestOnChunk(t *testing.T) {
+	const numNodes = 3
+	encoding := EncodingParams{SymbolSize: 1200, Alignment: 4, SubBlocks: 1, ChunkSize: 12000}
+	c := newTestCluster(t, numNodes, nil)
+	c.nodes[2].OnStream(func(info StreamInfo) (io.WriterAt, error) { return &memWriterAt{}, nil })
+	var mux sync.Mutex
+	chunks := make([][]Chunk, numNodes)
+	delivered := make(chan int, numNodes)
+	for i, node := range c.nodes {
+		i := i
+		node.OnChunk(func(chunk Chunk) {
+			mux.Lock()
+			defer mux.Unlock()
+			chunks[i] = append(chunks[i], chunk)
+		})
+		node.OnMessage(func(msg Message) {
+			mux.Lock()
+			defer mux.Unlock()
+			delivered <- len(chunks[i])
+		})
+	}
+	c.start(t)
+	defer c.close()
+
+	msg := make([]byte, 3*int(encoding.ChunkSize)+1000)
+	rand.Read(msg)
+	h, err := c.nodes[0].BroadCastReader(bytes.NewReader(msg), int64(len(msg)), c.conns[0], BroadCastOptions{Encoding: encoding})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	for i := 1; i < numNodes; i++ {
+		select {
+		case n := <-delivered:
+			if n != 4 {
+				t.Errorf("message delivered after %v chunks, want 4", n)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%v of %v nodes delivered the message", i-1, numNodes-1)
+		}
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(chunks[0]) != 0 {
+		t.Errorf("sender handed over %v chunks of its own message", len(chunks[0]))
+	}
+	for i := 1; i < numNodes; i++ {
+		seen := make(map[int]bool)
+		for _, chunk := range chunks[i] {
+			if seen[chunk.ChunkID] {
+				t.Errorf("node %v: chunk %v handed over twice", i, chunk.ChunkID)
+			}
+			seen[chunk.ChunkID] = true
+			if chunk.NumChunks != 4 || chunk.SenderID != 0 {
+				t.Errorf("node %v: chunk %v of %v chunks of sender %v, want 4 chunks of sender 0", i, chunk.ChunkID, chunk.NumChunks, chunk.SenderID)
+			}
+			if want := int64(chunk.ChunkID) * int64(encoding.ChunkSize); chunk.Offset != want {
+				t.Errorf("node %v: chunk %v at offset %v, want %v", i, chunk.ChunkID, chunk.Offset, want)
+				continue
+			}
+			end := chunk.Offset + int64(encoding.ChunkSize)
+			if end > int64(len(msg)) {
+				end = int64(len(msg))
+			}
+			if !bytes.Equal(chunk.Data, msg[chunk.Offset:end]) {
+				t.Errorf("node %v: chunk %v differs from the bytes %v to %v of the message", i, chunk.ChunkID, chunk.Offset, end)
+			}
+		}
+		if len(seen) != 4 {
+			t.Errorf("node %v handed over chunks %v, want 0 to 3", i, seen)
+		}
+	}
+}
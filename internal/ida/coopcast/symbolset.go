@@ -0,0 +1,55 @@
+package coopcast
+
+// symbolWindowWords is the size of the sliding bitmap of a chunk in 64 bit words
+const symbolWindowWords = 64
+
+// symbolSet records the ESIs of the symbols received for a chunk in a sliding bitmap of
+// symbolWindowWords*64 bits. The sender emits the ESIs of a chunk in increasing order, so the window
+// slides forward with the newest symbol and symbols older than the window are treated as received.
+type symbolSet struct {
+	base uint32 // ESI of the first bit, multiple of 64
+	bits [symbolWindowWords]uint64
+}
+
+// testAndSet marks esi as received and reports whether it was received before
+func (set *symbolSet) testAndSet(esi uint32) bool {
+	if esi < set.base {
+		return true
+	}
+	offset := uint64(esi - set.base)
+	if offset >= symbolWindowWords*64 {
+		set.slide(offset/64 - symbolWindowWords + 1)
+		offset = uint64(esi - set.base)
+	}
+	word, bit := offset/64, uint64(1)<<(offset%64)
+	if set.bits[word]&bit != 0 {
+		return true
+	}
+	set.bits[word] |= bit
+	return false
+}
+
+// test reports whether esi was received, without marking it
+func (set *symbolSet) test(esi uint32) bool {
+	if esi < set.base {
+		return true
+	}
+	offset := uint64(esi - set.base)
+	if offset >= symbolWindowWords*64 {
+		return false
+	}
+	return set.bits[offset/64]&(uint64(1)<<(offset%64)) != 0
+}
+
+// slide moves the window forward by n words
+func (set *symbolSet) slide(n uint64) {
+	if n >= symbolWindowWords {
+		set.bits = [symbolWindowWords]uint64{}
+	} else {
+		copy(set.bits[:], set.bits[n:])
+		for i := symbolWindowWords - n; i < symbolWindowWords; i++ {
+			set.bits[i] = 0
+		}
+	}
+	set.base += uint32(n * 64)
+}
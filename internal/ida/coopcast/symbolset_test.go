@@ -0,0 +1,58 @@
+package coopcast
+
+import "testing"
+
+// TestSymbolSet checks duplicate detection inside the window, and how the window slides with newer ESIs
+func TestSymbolSet(t *testing.T) {
+	const window = symbolWindowWords * 64
+	var set symbolSet
+	steps := []struct {
+		name string
+		esi  uint32
+		seen bool
+		base uint32
+	}{
+		{"first", 0, false, 0},
+		{"duplicate", 0, true, 0},
+		{"end of the window", window - 1, false, 0},
+		{"duplicate at the end of the window", window - 1, true, 0},
+		{"slide by one word", window, false, 64},
+		{"older than the window", 63, true, 64},
+		{"start of the window kept", window - 1, true, 64},
+		{"new in the window", 64, false, 64},
+		{"slide by another word", window + 64 + 1, false, 128},
+		{"kept after the slide", window, true, 128},
+		{"dropped by the slide", 64, true, 128},
+		{"new after the slide", 128, false, 128},
+		{"slide by a whole window", 2*window + 64, false, window + 128},
+		{"older than the new window", window + 64 + 1, true, window + 128},
+		{"cleared by the slide", window + 128, false, window + 128},
+		{"jump over the window", 10 * window, false, 9*window + 64},
+		{"cleared by the jump", 10*window - 1, false, 9*window + 64},
+		{"duplicate after the jump", 10 * window, true, 9*window + 64},
+		{"largest ESI", ^uint32(0), false, ^uint32(0) - window + 1},
+		{"duplicate largest ESI", ^uint32(0), true, ^uint32(0) - window + 1},
+		{"start of the last window", ^uint32(0) - window + 1, false, ^uint32(0) - window + 1},
+	}
+	for _, step := range steps {
+		if seen := set.testAndSet(step.esi); seen != step.seen {
+			t.Errorf("%v: testAndSet(%v) = %v, want %v", step.name, step.esi, seen, step.seen)
+		}
+		if set.base != step.base {
+			t.Errorf("%v: window starts at %v, want %v", step.name, set.base, step.base)
+		}
+	}
+}
+
+// TestSymbolSetOrdered checks that every ESI sent in order is new once and a duplicate afterwards
+func TestSymbolSetOrdered(t *testing.T) {
+	var set symbolSet
+	for esi := uint32(0); esi < 10*symbolWindowWords*64; esi++ {
+		if set.testAndSet(esi) {
+			t.Fatalf("new ESI %v reported as received", esi)
+		}
+		if !set.testAndSet(esi) {
+			t.Fatalf("duplicate ESI %v reported as new", esi)
+		}
+	}
+}
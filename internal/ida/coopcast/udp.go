@@ -0,0 +1,38 @@
+package coopcast
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// datagrams read or written by a single system call where the platform supports it
+const (
+	readBatchSize  int = 16 // every datagram of a read batch takes a buffer of udpCacheSize bytes
+	sendBatchSize  int = 32
+	relayBatchSize int = 32
+
+	maxSendLag time.Duration = time.Millisecond // lag of the send schedule caught up by a batch, it covers the timer granularity
+)
+
+// resolvePeers resolves the datagram address of every neighbor and of every peer once, so that no symbol pays for
+// the lookup. The address of a peer which cannot be resolved is nil and the peer is skipped.
+func (node *Node) resolvePeers() {
+	node.peerAddrs = node.resolve(node.PeerList)
+	node.allAddrs = node.resolve(node.AllPeers)
+}
+
+// resolve returns the datagram address of every peer, nil if it cannot be resolved
+func (node *Node) resolve(peers []Peer) []net.Addr {
+	addrs := make([]net.Addr, len(peers))
+	for i, peer := range peers {
+		remoteAddr := net.JoinHostPort(peer.IP, peer.UDPPort)
+		addr, err := node.Transport.ResolveAddr(remoteAddr)
+		if err != nil {
+			log.Printf("cannot resolve udp address %v: %v", remoteAddr, err)
+			continue
+		}
+		addrs[i] = addr
+	}
+	return addrs
+}
@@ -0,0 +1,88 @@
+// Package udpbatch reads and writes several UDP datagrams per system call.
+//
+// On Linux the datagrams of a batch go through a single recvmmsg or sendmmsg call of
+// golang.org/x/net/ipv4. Other platforms, and connections which are not a *net.UDPConn,
+// fall back to one ReadFrom or WriteTo call per datagram with the same API.
+package udpbatch
+
+import (
+	"io"
+	"net"
+)
+
+// Message is a datagram of a batch
+type Message struct {
+	Buf  []byte   // payload to write, or buffer to read into
+	N    int      // bytes read into Buf
+	Addr net.Addr // destination of a written datagram, source of a read one
+}
+
+// Conn wraps a net.PacketConn to read and write batches of datagrams.
+// A Conn is safe for concurrent use if the underlying net.PacketConn is.
+type Conn struct {
+	pc      net.PacketConn
+	batched batchConn // nil if the datagrams are read and written one by one
+}
+
+// batchConn is implemented by the platform specific batch I/O
+type batchConn interface {
+	readBatch(msgs []Message) (int, error)
+	writeBatch(msgs []Message) (int, error)
+}
+
+// NewConn returns a Conn reading and writing the datagrams of pc
+func NewConn(pc net.PacketConn) *Conn {
+	return &Conn{pc: pc, batched: newBatchConn(pc)}
+}
+
+// Batched reports whether the datagrams of a batch share a single system call
+func (c *Conn) Batched() bool {
+	return c.batched != nil
+}
+
+// ReadBatch blocks until at least one datagram is received and returns the number of messages filled,
+// it reads at most len(msgs) datagrams
+func (c *Conn) ReadBatch(msgs []Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	if c.batched != nil {
+		return c.batched.readBatch(msgs)
+	}
+	n, addr, err := c.pc.ReadFrom(msgs[0].Buf)
+	if err != nil {
+		return 0, err
+	}
+	msgs[0].N, msgs[0].Addr = n, addr
+	return 1, nil
+}
+
+// WriteBatch writes every message to its address and returns the number of messages written,
+// which is less than len(msgs) only if err is not nil
+func (c *Conn) WriteBatch(msgs []Message) (int, error) {
+	written := 0
+	for written < len(msgs) {
+		var n int
+		var err error
+		if c.batched != nil {
+			n, err = c.batched.writeBatch(msgs[written:])
+		} else {
+			n, err = c.writeOne(msgs[written])
+		}
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+func (c *Conn) writeOne(msg Message) (int, error) {
+	if _, err := c.pc.WriteTo(msg.Buf, msg.Addr); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
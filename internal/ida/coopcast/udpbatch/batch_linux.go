@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package udpbatch
+
+import (
+	"golang.org/x/net/ipv4"
+	"net"
+)
+
+// mmsgConn uses recvmmsg and sendmmsg, the ipv4 package handles IPv6 sockets the same way
+type mmsgConn struct {
+	conn *ipv4.PacketConn
+}
+
+func newBatchConn(pc net.PacketConn) batchConn {
+	uc, ok := pc.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	return &mmsgConn{conn: ipv4.NewPacketConn(uc)}
+}
+
+func (c *mmsgConn) readBatch(msgs []Message) (int, error) {
+	ms := make([]ipv4.Message, len(msgs))
+	buffers := make([][]byte, len(msgs))
+	for i := range msgs {
+		buffers[i] = msgs[i].Buf
+		ms[i].Buffers = buffers[i : i+1]
+	}
+	n, err := c.conn.ReadBatch(ms, 0)
+	for i := 0; i < n; i++ {
+		msgs[i].N, msgs[i].Addr = ms[i].N, ms[i].Addr
+	}
+	return n, err
+}
+
+func (c *mmsgConn) writeBatch(msgs []Message) (int, error) {
+	ms := make([]ipv4.Message, len(msgs))
+	buffers := make([][]byte, len(msgs))
+	for i := range msgs {
+		buffers[i] = msgs[i].Buf
+		ms[i].Buffers = buffers[i : i+1]
+		ms[i].Addr = msgs[i].Addr
+	}
+	return c.conn.WriteBatch(ms, 0)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package udpbatch
+
+import (
+	"net"
+)
+
+// newBatchConn returns nil, datagrams are read and written one by one outside of Linux
+func newBatchConn(pc net.PacketConn) batchConn {
+	return nil
+}
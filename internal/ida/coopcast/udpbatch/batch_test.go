@@ -0,0 +1,113 @@
+package udpbatch
+
+import (
+	"net"
+	"testing"
+)
+
+// sizes of the benchmarked datagrams and batches, about the ones of the coopcast symbols
+const (
+	benchDatagramSize = 1200
+	benchBatchSize    = 32
+)
+
+// singleConn hides the *net.UDPConn of a connection, so that NewConn falls back to one datagram per system call
+type singleConn struct {
+	net.PacketConn
+}
+
+// benchModes are the connections compared by the benchmarks
+var benchModes = []struct {
+	name string
+	wrap func(pc net.PacketConn) net.PacketConn
+}{
+	{"mmsg", func(pc net.PacketConn) net.PacketConn { return pc }},
+	{"single", func(pc net.PacketConn) net.PacketConn { return singleConn{pc} }},
+}
+
+func listenLoopback(b *testing.B) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Skipf("no loopback UDP socket: %v", err)
+	}
+	b.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+func benchBatch(to net.Addr) []Message {
+	msgs := make([]Message, benchBatchSize)
+	for i := range msgs {
+		msgs[i] = Message{Buf: make([]byte, benchDatagramSize), Addr: to}
+	}
+	return msgs
+}
+
+// BenchmarkWriteBatch measures the datagrams sent per second, a datagram per iteration
+func BenchmarkWriteBatch(b *testing.B) {
+	for _, mode := range benchModes {
+		mode := mode
+		b.Run(mode.name, func(b *testing.B) {
+			receiver := listenLoopback(b)
+			conn := NewConn(mode.wrap(listenLoopback(b)))
+			msgs := benchBatch(receiver.LocalAddr())
+			b.SetBytes(benchDatagramSize)
+			b.ResetTimer()
+			for sent := 0; sent < b.N; {
+				batch := msgs
+				if b.N-sent < len(batch) {
+					batch = batch[:b.N-sent]
+				}
+				n, err := conn.WriteBatch(batch)
+				if err != nil {
+					b.Fatal(err)
+				}
+				sent += n
+			}
+		})
+	}
+}
+
+// BenchmarkReadBatch measures the datagrams received per second, a datagram per iteration.
+// The datagrams are sent by another goroutine as fast as possible.
+func BenchmarkReadBatch(b *testing.B) {
+	for _, mode := range benchModes {
+		mode := mode
+		b.Run(mode.name, func(b *testing.B) {
+			pc := listenLoopback(b)
+			conn := NewConn(mode.wrap(pc))
+			sender := NewConn(listenLoopback(b))
+			done := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				msgs := benchBatch(pc.LocalAddr())
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					sender.WriteBatch(msgs)
+				}
+			}()
+			defer func() {
+				close(done)
+				<-stopped
+			}()
+			msgs := benchBatch(nil)
+			b.SetBytes(benchDatagramSize)
+			b.ResetTimer()
+			for received := 0; received < b.N; {
+				batch := msgs
+				if b.N-received < len(batch) {
+					batch = batch[:b.N-received]
+				}
+				n, err := conn.ReadBatch(batch)
+				if err != nil {
+					b.Fatal(err)
+				}
+				received += n
+			}
+		})
+	}
+}
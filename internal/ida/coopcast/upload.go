@@ -0,0 +1,176 @@
+package coopcast
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// default limits of the upload budget
+const (
+	defaultUploadBurst int64 = 256 << 10
+	maxTrafficMessages int   = 4096 // messages accounted one by one, the traffic of the other ones only counts in UploadStats
+)
+
+// UploadLimits bounds the bytes of the symbols sent by a node, broadcast and relayed symbols together. Symbols wait
+// for the budget of the node, so relays pile up in the relay queue and are dropped when it is full, while the
+// symbols of a message which used its own budget are not sent nor relayed anymore by the node, its broadcast
+// stops with ErrUploadCapExceeded.
+type UploadLimits struct {
+	BytesPerSecond int64 // unlimited if unset
+	Burst          int64 // bytes sent at once after an idle period, 256 KiB if unset
+	MessageBytes   int64 // bytes sent per message, unlimited if unset
+}
+
+func (limits UploadLimits) orDefault() UploadLimits {
+	if limits.Burst <= 0 {
+		limits.Burst = defaultUploadBurst
+	}
+	return limits
+}
+
+// Traffic reports the bytes of the symbols of a message sent and received by a node
+type Traffic struct {
+	Upload   int64 // symbols broadcast or relayed
+	Download int64 // authenticated symbols received and duplicates of them
+	Dropped  int64 // symbols not sent because the message used its MessageBytes
+}
+
+// Ratio returns Upload over Download, +Inf for a message the node sent without receiving anything of it
+func (t Traffic) Ratio() float64 {
+	if t.Download == 0 {
+		if t.Upload == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return float64(t.Upload) / float64(t.Download)
+}
+
+// UploadStats reports the usage of the upload budget
+type UploadStats struct {
+	Upload         uint64        // bytes of every symbol sent
+	Download       uint64        // bytes of every authenticated symbol received and of their duplicates
+	Dropped        uint64        // bytes not sent because their message used its MessageBytes
+	Throttled      time.Duration // time the senders and relay workers waited for the budget
+	CappedMessages uint64        // broadcasts stopped with ErrUploadCapExceeded
+}
+
+// messageTraffic is the traffic of a message and its last update, UnixNano time
+type messageTraffic struct {
+	Traffic
+	updated int64
+}
+
+// uploadBudget is the token bucket of UploadLimits.BytesPerSecond and the traffic of the recent messages
+type uploadBudget struct {
+	limits    UploadLimits
+	bucket    *gcra // nil if the rate is unlimited
+	throttled int64 // nanoseconds, accessed atomically
+
+	mux      sync.Mutex
+	messages map[SessionKey]*messageTraffic
+}
+
+func newUploadBudget(limits UploadLimits) *uploadBudget {
+	budget := &uploadBudget{limits: limits, messages: make(map[SessionKey]*messageTraffic)}
+	if limits.BytesPerSecond > 0 {
+		budget.bucket = &gcra{rate: float64(limits.BytesPerSecond), burst: float64(limits.Burst)}
+	}
+	return budget
+}
+
+// message returns the traffic of a message, nil if too many messages are accounted, caller must hold budget.mux
+func (budget *uploadBudget) message(key SessionKey, now int64) *messageTraffic {
+	traffic := budget.messages[key]
+	if traffic == nil {
+		if len(budget.messages) >= maxTrafficMessages {
+			return nil
+		}
+		traffic = &messageTraffic{}
+		budget.messages[key] = traffic
+	}
+	traffic.updated = now
+	return traffic
+}
+
+// Traffic returns the traffic of the messages the node sent or received recently
+func (node *Node) Traffic() map[SessionKey]Traffic {
+	budget := node.upload
+	budget.mux.Lock()
+	defer budget.mux.Unlock()
+	traffic := make(map[SessionKey]Traffic, len(budget.messages))
+	for key, t := range budget.messages {
+		traffic[key] = t.Traffic
+	}
+	return traffic
+}
+
+// UploadStats returns a snapshot of the upload budget usage
+func (node *Node) UploadStats() UploadStats {
+	return UploadStats{
+		Upload:         atomic.LoadUint64(&node.uploadStats.Upload),
+		Download:       atomic.LoadUint64(&node.uploadStats.Download),
+		Dropped:        atomic.LoadUint64(&node.uploadStats.Dropped),
+		Throttled:      time.Duration(atomic.LoadInt64(&node.upload.throttled)),
+		CappedMessages: atomic.LoadUint64(&node.uploadStats.CappedMessages),
+	}
+}
+
+// admitUpload charges n bytes to the upload of a message, it returns false if the message used its budget
+func (node *Node) admitUpload(key SessionKey, n int) bool {
+	budget := node.upload
+	budget.mux.Lock()
+	traffic := budget.message(key, time.Now().UnixNano())
+	if traffic != nil && budget.limits.MessageBytes > 0 && traffic.Upload+int64(n) > budget.limits.MessageBytes {
+		traffic.Dropped += int64(n)
+		budget.mux.Unlock()
+		atomic.AddUint64(&node.uploadStats.Dropped, uint64(n))
+		return false
+	}
+	if traffic != nil {
+		traffic.Upload += int64(n)
+	}
+	budget.mux.Unlock()
+	atomic.AddUint64(&node.uploadStats.Upload, uint64(n))
+	return true
+}
+
+// accountDownload adds n bytes to the download of a message
+func (node *Node) accountDownload(key SessionKey, n int) {
+	budget := node.upload
+	budget.mux.Lock()
+	if traffic := budget.message(key, time.Now().UnixNano()); traffic != nil {
+		traffic.Download += int64(n)
+	}
+	budget.mux.Unlock()
+	atomic.AddUint64(&node.uploadStats.Download, uint64(n))
+}
+
+// throttleUpload waits until the upload budget of the node allows n more bytes, it returns false if ctx is done first
+func (node *Node) throttleUpload(ctx context.Context, n int) bool {
+	budget := node.upload
+	if budget.bucket == nil {
+		return true
+	}
+	wait := time.Until(budget.bucket.reserve(time.Now(), float64(n)))
+	if wait <= 0 {
+		return true
+	}
+	atomic.AddInt64(&budget.throttled, int64(wait))
+	return sleepContext(ctx, wait)
+}
+
+// clearTraffic forgets the messages without traffic for blacklistTime
+func (node *Node) clearTraffic(currentTime int64) {
+	budget := node.upload
+	budget.mux.Lock()
+	defer budget.mux.Unlock()
+	for key, traffic := range budget.messages {
+		if currentTime-traffic.updated > int64(blacklistTime*time.Second) {
+			delete(budget.messages, key)
+		}
+	}
+}
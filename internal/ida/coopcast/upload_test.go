@@ -0,0 +1,129 @@
+package coopcast
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TestAdmitUpload checks that a message is not sent beyond its MessageBytes and that its traffic is accounted
+func TestAdmitUpload(t *testing.T) {
+	node := newTestCluster(t, 1, func(i int, opts *Options) { opts.Upload.MessageBytes = 100 }).nodes[0]
+	a, b := testSession(1, 1), testSession(2, 2)
+	tests := []struct {
+		key SessionKey
+		n   int
+		ok  bool
+	}{
+		{a, 60, true},
+		{a, 50, false},
+		{a, 40, true},
+		{a, 1, false},
+		{b, 100, true},
+	}
+	for i, test := range tests {
+		if ok := node.admitUpload(test.key, test.n); ok != test.ok {
+			t.Errorf("%v: admitUpload(%v bytes) = %v, want %v", i, test.n, ok, test.ok)
+		}
+	}
+	node.accountDownload(a, 30)
+	if traffic := node.Traffic()[a]; traffic != (Traffic{Upload: 100, Download: 30, Dropped: 51}) {
+		t.Errorf("traffic %+v, want 100 bytes up, 30 down and 51 dropped", traffic)
+	}
+	if stats := node.UploadStats(); stats != (UploadStats{Upload: 200, Download: 30, Dropped: 51}) {
+		t.Errorf("stats %+v, want 200 bytes up, 30 down and 51 dropped", stats)
+	}
+	node.clearTraffic(time.Now().Add(blacklistTime*time.Second + time.Second).UnixNano())
+	if traffic := node.Traffic(); len(traffic) != 0 {
+		t.Errorf("traffic of idle messages %v not cleared", traffic)
+	}
+}
+
+// TestTrafficRatio checks the upload over download ratio of a message
+func TestTrafficRatio(t *testing.T) {
+	tests := []struct {
+		traffic Traffic
+		ratio   float64
+	}{
+		{Traffic{}, 0},
+		{Traffic{Upload: 10}, math.Inf(1)},
+		{Traffic{Upload: 10, Download: 20}, 0.5},
+		{Traffic{Download: 20}, 0},
+	}
+	for _, test := range tests {
+		if ratio := test.traffic.Ratio(); ratio != test.ratio {
+			t.Errorf("%+v: Ratio() = %v, want %v", test.traffic, ratio, test.ratio)
+		}
+	}
+}
+
+// TestGCRAReserve checks that reservations within the burst are due at once, and later ones at the rate
+func TestGCRAReserve(t *testing.T) {
+	bucket := &gcra{rate: 1000, burst: 100}
+	// in the future so that the clock does not move the schedule
+	at := time.Now().Add(time.Hour)
+	tests := []struct {
+		n   float64
+		due time.Duration
+	}{
+		{100, 0},
+		{50, 50 * time.Millisecond},
+		{300, 350 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if due := bucket.reserve(at, test.n).Sub(at); due != test.due {
+			t.Errorf("reservation of %v tokens due after %v, want %v", test.n, due, test.due)
+		}
+	}
+}
+
+// TestThrottleUpload checks that the symbols wait for the upload rate of the node, unless it is unlimited
+func TestThrottleUpload(t *testing.T) {
+	c := newTestCluster(t, 2, func(i int, opts *Options) {
+		if i == 0 {
+			opts.Upload = UploadLimits{BytesPerSecond: 1000, Burst: 100}
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	if !c.nodes[0].throttleUpload(ctx, 100) {
+		t.Errorf("burst throttled")
+	}
+	cancel()
+	if c.nodes[0].throttleUpload(ctx, 1000) {
+		t.Errorf("symbol sent beyond the rate after the context was canceled")
+	}
+	if throttled := c.nodes[0].UploadStats().Throttled; throttled < 900*time.Millisecond {
+		t.Errorf("throttled for %v, want about a second", throttled)
+	}
+	if !c.nodes[1].throttleUpload(ctx, 1<<20) || c.nodes[1].UploadStats().Throttled != 0 {
+		t.Errorf("unlimited upload throttled")
+	}
+}
+
+// TestBroadCastUploadCap checks that a broadcast stops with ErrUploadCapExceeded once its message used MessageBytes
+func TestBroadCastUploadCap(t *testing.T) {
+	c := newTestCluster(t, 3, func(i int, opts *Options) {
+		opts.Upload.MessageBytes = 32 << 10
+	})
+	c.start(t)
+	defer c.close()
+
+	msg := make([]byte, 256<<10)
+	rand.Read(msg)
+	h, err := c.nodes[0].BroadCast(msg, c.conns[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Wait(); err != ErrUploadCapExceeded {
+		t.Fatalf("Wait() = %v, want %v", err, ErrUploadCapExceeded)
+	}
+	stats := c.nodes[0].UploadStats()
+	if stats.CappedMessages != 1 {
+		t.Errorf("%v capped messages, want 1", stats.CappedMessages)
+	}
+	if stats.Upload > 32<<10 {
+		t.Errorf("sent %v bytes, the message cap is %v", stats.Upload, 32<<10)
+	}
+}
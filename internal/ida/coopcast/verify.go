@@ -0,0 +1,166 @@
+package coopcast
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCorruptObject is reported when a decoded chunk or message does not match its advertised hash
+var ErrCorruptObject = errors.New("decoded object does not match its hash")
+
+// CorruptObjectError describes a message discarded because of a hash mismatch
+type CorruptObjectError struct {
+	RootHash []byte
+	Sender   PeerID
+	ChunkID  int // chunk failing its hash, -1 if the reassembled message fails the root hash
+}
+
+func (e *CorruptObjectError) Error() string {
+	if e.ChunkID < 0 {
+		return fmt.Sprintf("message %x from sender %v: %v", e.RootHash, e.Sender, ErrCorruptObject)
+	}
+	return fmt.Sprintf("chunkID=%v of message %x from sender %v: %v", e.ChunkID, e.RootHash, e.Sender, ErrCorruptObject)
+}
+
+// Unwrap makes errors.Is(err, ErrCorruptObject) hold for a CorruptObjectError
+func (e *CorruptObjectError) Unwrap() error {
+	return ErrCorruptObject
+}
+
+// DeliveryStats counts the outcome of messages received by a node
+type DeliveryStats struct {
+	Delivered          uint64 // messages handed over to the MessageHandlers
+	CorruptObjects     uint64 // messages discarded because a chunk or the root hash did not match
+	BlacklistedPackets uint64 // packets dropped because their relay is blacklisted
+}
+
+// OnError registers a handler which is called for every message the node discards
+func (node *Node) OnError(handler ErrorHandler) {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.errHandlers = append(node.errHandlers, handler)
+}
+
+// DeliveryStats returns a snapshot of the delivery counters
+func (node *Node) DeliveryStats() DeliveryStats {
+	return DeliveryStats{
+		Delivered:          atomic.LoadUint64(&node.deliveryStats.Delivered),
+		CorruptObjects:     atomic.LoadUint64(&node.deliveryStats.CorruptObjects),
+		BlacklistedPackets: atomic.LoadUint64(&node.deliveryStats.BlacklistedPackets),
+	}
+}
+
+// rejectObject discards the cached state of a corrupt message, ignores the messages of its sender for
+// blacklistTime and reports the error. The symbols are signed by the sender, so a relay cannot corrupt them.
+func (node *Node) rejectObject(key SessionKey, corrupt *CorruptObjectError) {
+	log.Printf("rejecting corrupt object: %v", corrupt)
+	atomic.AddUint64(&node.deliveryStats.CorruptObjects, 1)
+	node.mux.Lock()
+	node.rejectedSenders[key.Sender] = time.Now().UnixNano()
+	node.mux.Unlock()
+	node.discardObject(key, corrupt)
+}
+
+// discardObject releases the cached state of a message, ignores its symbols for blacklistTime and reports err
+func (node *Node) discardObject(key SessionKey, err error) {
+	node.mux.Lock()
+	raptorq := node.Cache[key]
+	if raptorq != nil {
+		node.uncharge(key, raptorq, raptorq.memory)
+	}
+	delete(node.Cache, key)
+	node.rejected[key] = time.Now().UnixNano()
+	handlers := make([]ErrorHandler, len(node.errHandlers))
+	copy(handlers, node.errHandlers)
+	node.mux.Unlock()
+	if raptorq != nil {
+		raptorq.release()
+	}
+	for _, handler := range handlers {
+		handler(err)
+	}
+}
+
+// blacklistRelay ignores the packets received from addr for blacklistTime
+func (node *Node) blacklistRelay(addr string) {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.blacklist[addr] = time.Now().UnixNano() + int64(blacklistTime*time.Second)
+}
+
+func (node *Node) isBlacklisted(addr string) bool {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	expiry, ok := node.blacklist[addr]
+	if !ok {
+		return false
+	}
+	if time.Now().UnixNano() > expiry {
+		delete(node.blacklist, addr)
+		return false
+	}
+	atomic.AddUint64(&node.deliveryStats.BlacklistedPackets, 1)
+	return true
+}
+
+func (node *Node) isRejected(key SessionKey) bool {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	if _, ok := node.rejected[key]; ok {
+		return true
+	}
+	_, ok := node.rejectedSenders[key.Sender]
+	return ok
+}
+
+// clearRejected forgets rejected and evicted messages and expired blacklist entries, caller must hold node.mux
+func (node *Node) clearRejected(currentTime int64) {
+	for k, v := range node.rejected {
+		if currentTime-v > int64(blacklistTime*time.Second) {
+			delete(node.rejected, k)
+		}
+	}
+	for k, v := range node.rejectedSenders {
+		if currentTime-v > int64(blacklistTime*time.Second) {
+			delete(node.rejectedSenders, k)
+		}
+	}
+	for k, v := range node.finished {
+		if currentTime-v > int64(blacklistTime*time.Second) {
+			delete(node.finished, k)
+		}
+	}
+	for addr, expiry := range node.blacklist {
+		if currentTime > expiry {
+			delete(node.blacklist, addr)
+		}
+	}
+}
+
+// merkleRoot recomputes the root hash of a reassembled message, caller must hold raptorq.mux
+func (raptorq *RaptorQImpl) merkleRoot(payload []byte) []byte {
+	leaves := make([][]byte, raptorq.numChunks)
+	var offset int
+	for z := 0; z < raptorq.numChunks; z++ {
+		size := int(raptorq.Decoder[z].TransferLength())
+		leaves[z] = raptorq.hashType.chunkHash(payload[offset : offset+size])
+		offset += size
+	}
+	return raptorq.hashType.merkleRoot(leaves)
+}
+
+// recordChunkHash remembers the chunk hash advertised by the sender.
+// It returns false if chunkHash differs from the one received first for the chunk.
+func (raptorq *RaptorQImpl) recordChunkHash(chunkID int, chunkHash []byte) bool {
+	raptorq.mux.Lock()
+	defer raptorq.mux.Unlock()
+	if expected, ok := raptorq.chunkHashes[chunkID]; ok && !bytes.Equal(expected, chunkHash) {
+		return false
+	}
+	raptorq.chunkHashes[chunkID] = chunkHash
+	return true
+}
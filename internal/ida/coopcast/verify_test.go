@@ -0,0 +1,70 @@
+package coopcast
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestRejectObject checks that a corrupt message is dropped, reported and rejects the messages of its sender
+// until it expires
+func TestRejectObject(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	node := c.nodes[0]
+	var reported []error
+	node.OnError(func(err error) { reported = append(reported, err) })
+	root := bytes.Repeat([]byte{1}, hashSize)
+	key := SessionKey{Sender: PeerID{1}, Root: convertToFixedSize(root)}
+	node.initRaptorQIfNotExist(SHA256, key)
+
+	node.rejectObject(key, &CorruptObjectError{RootHash: root, Sender: key.Sender, ChunkID: 0})
+	if _, ok := node.Cache[key]; ok {
+		t.Errorf("corrupt message still cached")
+	}
+	if !node.isRejected(key) || !node.isRejected(SessionKey{Sender: key.Sender, Root: HashKey{2}}) {
+		t.Errorf("messages of the sender of a corrupt message not rejected")
+	}
+	if node.isRejected(SessionKey{Sender: PeerID{2}, Root: key.Root}) {
+		t.Errorf("message of another sender with the same root rejected")
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], ErrCorruptObject) {
+		t.Errorf("reported %v, want one %v", reported, ErrCorruptObject)
+	}
+	if stats := node.DeliveryStats(); stats != (DeliveryStats{CorruptObjects: 1}) {
+		t.Errorf("stats %+v", stats)
+	}
+
+	node.blacklistRelay("127.0.0.1:40000")
+	if !node.isBlacklisted("127.0.0.1:40000") || node.isBlacklisted("127.0.0.1:40001") {
+		t.Errorf("only the blacklisted relay should be blacklisted")
+	}
+	node.mux.Lock()
+	node.clearRejected(time.Now().Add(blacklistTime*time.Second + time.Second).UnixNano())
+	node.mux.Unlock()
+	if node.isRejected(key) || node.isRejected(SessionKey{Sender: key.Sender, Root: HashKey{2}}) || node.isBlacklisted("127.0.0.1:40000") {
+		t.Errorf("rejection and blacklist did not expire")
+	}
+}
+
+// TestRecordChunkHash checks that the first chunk hash received wins
+func TestRecordChunkHash(t *testing.T) {
+	raptorq := &RaptorQImpl{chunkHashes: make(map[int][]byte)}
+	first, other := bytes.Repeat([]byte{1}, hashSize), bytes.Repeat([]byte{2}, hashSize)
+	tests := []struct {
+		chunkID int
+		hash    []byte
+		ok      bool
+	}{
+		{0, first, true},
+		{0, first, true},
+		{0, other, false},
+		{1, other, true},
+		{1, first, false},
+	}
+	for _, test := range tests {
+		if ok := raptorq.recordChunkHash(test.chunkID, test.hash); ok != test.ok {
+			t.Errorf("recordChunkHash(%v, %x) = %v, want %v", test.chunkID, test.hash[0], ok, test.ok)
+		}
+	}
+}
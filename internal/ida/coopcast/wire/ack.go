@@ -0,0 +1,66 @@
+package wire
+
+// AckPacket acknowledges to the sender that a chunk has been decoded:
+//
+//	|header(4)|hashType(1)|rootHash(32)|chunkID(4)|received(4)|maxSymbolID(4)|peer(32)|signature(64)|
+//
+// Received and MaxSymbolID describe the symbols of the chunk the peer got until it decoded the chunk,
+// they give the sender a loss and a round trip time sample. The signature is made with the key of the
+// acknowledging peer and covers the whole packet but itself.
+type AckPacket struct {
+	HashType    byte
+	RootHash    []byte
+	ChunkID     uint32
+	Received    uint32 // distinct symbols of the chunk received by the peer
+	MaxSymbolID uint32 // highest symbol ID of the chunk received by the peer
+	Peer        []byte // ed25519 public key of the acknowledging peer
+	Signature   []byte
+}
+
+// AckPacketSize is the fixed length of an encoded AckPacket
+const AckPacketSize = HeaderSize + 1 + HashSize + 4 + 4 + 4 + PubKeySize + SignatureSize
+
+func (p *AckPacket) appendUnsigned(buf []byte) []byte {
+	buf = appendHeader(buf, TypeAck)
+	buf = append(buf, p.HashType)
+	buf = append(buf, p.RootHash...)
+	buf = appendUint32(buf, p.ChunkID)
+	buf = appendUint32(buf, p.Received)
+	buf = appendUint32(buf, p.MaxSymbolID)
+	return append(buf, p.Peer...)
+}
+
+// SignedBytes returns the bytes covered by the peer signature
+func (p *AckPacket) SignedBytes() ([]byte, error) {
+	if len(p.RootHash) != HashSize || len(p.Peer) != PubKeySize {
+		return nil, ErrInvalidField
+	}
+	return p.appendUnsigned(make([]byte, 0, AckPacketSize)), nil
+}
+
+// MarshalBinary encodes the packet
+func (p *AckPacket) MarshalBinary() ([]byte, error) {
+	if len(p.RootHash) != HashSize || len(p.Peer) != PubKeySize || len(p.Signature) != SignatureSize {
+		return nil, ErrInvalidField
+	}
+	buf := p.appendUnsigned(make([]byte, 0, AckPacketSize))
+	return append(buf, p.Signature...), nil
+}
+
+// UnmarshalBinary decodes a packet, the byte slices of p reference data
+func (p *AckPacket) UnmarshalBinary(data []byte) error {
+	r := newReader(data, TypeAck)
+	var q AckPacket
+	q.HashType = r.uint8()
+	q.RootHash = r.next(HashSize)
+	q.ChunkID = r.uint32()
+	q.Received = r.uint32()
+	q.MaxSymbolID = r.uint32()
+	q.Peer = r.next(PubKeySize)
+	q.Signature = r.next(SignatureSize)
+	if err := r.done(); err != nil {
+		return err
+	}
+	*p = q
+	return nil
+}
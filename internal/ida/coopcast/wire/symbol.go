@@ -0,0 +1,127 @@
+package wire
+
+// SymbolPacket carries one RaptorQ encoded symbol of a message chunk:
+//
+//	|header(4)|hashType(1)|rootHash(32)|hop(1)|sender(32)|numChunks(4)|chunkID(4)|chunkSize(4)|
+//	|commonOTI(8)|schemeSpecificOTI(4)|chunkHash(32)|proofLen(1)|proof(32*proofLen)|
+//	|symbolID(4)|symbolLen(2)|symbol|signature(64)|
+//
+// ChunkSize is the size of every chunk but the last one. The OTIs are the ones of the chunk encoder
+// as defined in RFC6330 section 3.3, the decoder of the receivers is created from them verbatim.
+//
+// The sender is the ed25519 public key of the broadcasting node, the signature covers the whole packet
+// except the hop, which relays decrease, and the signature itself.
+type SymbolPacket struct {
+	HashType  byte
+	RootHash  []byte
+	Hop       uint8
+	Sender    []byte
+	NumChunks uint32
+	ChunkID   uint32
+	ChunkSize uint32
+	// RaptorQ object transmission information of the chunk
+	CommonOTI         uint64
+	SchemeSpecificOTI uint32
+
+	ChunkHash []byte
+	Proof     [][]byte // merkle proof of ChunkHash against RootHash
+	SymbolID  uint32
+	Symbol    []byte
+	Signature []byte
+}
+
+// MinSymbolPacketSize is the size of a symbol packet with an empty proof and symbol
+const MinSymbolPacketSize = HeaderSize + 1 + HashSize + 1 + PubKeySize + 4 + 4 + 4 + 8 + 4 + HashSize + 1 + 4 + 2 + SignatureSize
+
+func (p *SymbolPacket) validate() error {
+	if len(p.RootHash) != HashSize || len(p.Sender) != PubKeySize || len(p.ChunkHash) != HashSize || len(p.Proof) > MaxProofLen {
+		return ErrInvalidField
+	}
+	for _, sibling := range p.Proof {
+		if len(sibling) != HashSize {
+			return ErrInvalidField
+		}
+	}
+	if len(p.Symbol) > 0xffff {
+		return ErrInvalidField
+	}
+	return nil
+}
+
+// Size returns the length of the encoded packet
+func (p *SymbolPacket) Size() int {
+	return MinSymbolPacketSize + len(p.Proof)*HashSize + len(p.Symbol)
+}
+
+func (p *SymbolPacket) appendUnsigned(buf []byte, hop uint8) []byte {
+	buf = appendHeader(buf, TypeSymbol)
+	buf = append(buf, p.HashType)
+	buf = append(buf, p.RootHash...)
+	buf = append(buf, hop)
+	buf = append(buf, p.Sender...)
+	buf = appendUint32(buf, p.NumChunks)
+	buf = appendUint32(buf, p.ChunkID)
+	buf = appendUint32(buf, p.ChunkSize)
+	buf = appendUint64(buf, p.CommonOTI)
+	buf = appendUint32(buf, p.SchemeSpecificOTI)
+	buf = append(buf, p.ChunkHash...)
+	buf = append(buf, byte(len(p.Proof)))
+	for _, sibling := range p.Proof {
+		buf = append(buf, sibling...)
+	}
+	buf = appendUint32(buf, p.SymbolID)
+	buf = appendUint16(buf, uint16(len(p.Symbol)))
+	return append(buf, p.Symbol...)
+}
+
+// SignedBytes returns the bytes covered by the sender signature
+func (p *SymbolPacket) SignedBytes() ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p.appendUnsigned(make([]byte, 0, p.Size()), 0), nil
+}
+
+// MarshalBinary encodes the packet
+func (p *SymbolPacket) MarshalBinary() ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if len(p.Signature) != SignatureSize {
+		return nil, ErrInvalidField
+	}
+	buf := p.appendUnsigned(make([]byte, 0, p.Size()), p.Hop)
+	return append(buf, p.Signature...), nil
+}
+
+// UnmarshalBinary decodes a packet, the byte slices of p reference data
+func (p *SymbolPacket) UnmarshalBinary(data []byte) error {
+	r := newReader(data, TypeSymbol)
+	var q SymbolPacket
+	q.HashType = r.uint8()
+	q.RootHash = r.next(HashSize)
+	q.Hop = r.uint8()
+	q.Sender = r.next(PubKeySize)
+	q.NumChunks = r.uint32()
+	q.ChunkID = r.uint32()
+	q.ChunkSize = r.uint32()
+	q.CommonOTI = r.uint64()
+	q.SchemeSpecificOTI = r.uint32()
+	q.ChunkHash = r.next(HashSize)
+	proofLen := int(r.uint8())
+	if proofLen > MaxProofLen {
+		return ErrInvalidField
+	}
+	q.Proof = make([][]byte, proofLen)
+	for i := range q.Proof {
+		q.Proof[i] = r.next(HashSize)
+	}
+	q.SymbolID = r.uint32()
+	q.Symbol = r.next(int(r.uint16()))
+	q.Signature = r.next(SignatureSize)
+	if err := r.done(); err != nil {
+		return err
+	}
+	*p = q
+	return nil
+}
@@ -0,0 +1,157 @@
+// Package wire defines the binary format of the packets exchanged by coopcast nodes.
+//
+// Every packet starts with a common header:
+//
+//	|magic(2)|version(1)|type(1)|
+//
+// followed by the body of the packet type. Integers are big endian.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Type identifies the kind of a packet
+type Type byte
+
+// packet types
+const (
+	TypeSymbol Type = 1 // encoded symbol of a message chunk, sent over UDP
+	TypeAck    Type = 2 // decode acknowledgement of a chunk, sent over TCP
+)
+
+// sizes of the wire format
+const (
+	Version       byte = 6 // version 6 reports the reception of the chunk in the acknowledgements
+	HeaderSize    int  = 4
+	HashSize      int  = 32
+	PubKeySize    int  = 32
+	SignatureSize int  = 64
+	MaxProofLen   int  = 32 // limits the merkle proof to 2^32 chunks
+)
+
+var magic = [2]byte{'C', 'C'}
+
+// errors returned when decoding malformed packets
+var (
+	ErrShortPacket        = errors.New("wire: packet too short")
+	ErrBadMagic           = errors.New("wire: bad magic")
+	ErrUnsupportedVersion = errors.New("wire: unsupported version")
+	ErrWrongType          = errors.New("wire: unexpected packet type")
+	ErrTrailingData       = errors.New("wire: trailing data after packet")
+	ErrInvalidField       = errors.New("wire: invalid field length")
+)
+
+// PeekType validates the common header of data and returns the packet type
+func PeekType(data []byte) (Type, error) {
+	if len(data) < HeaderSize {
+		return 0, ErrShortPacket
+	}
+	if data[0] != magic[0] || data[1] != magic[1] {
+		return 0, ErrBadMagic
+	}
+	if data[2] != Version {
+		return 0, ErrUnsupportedVersion
+	}
+	return Type(data[3]), nil
+}
+
+func appendHeader(buf []byte, t Type) []byte {
+	return append(buf, magic[0], magic[1], Version, byte(t))
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+// reader consumes a packet with bounds checking, the first error sticks
+type reader struct {
+	buf []byte
+	err error
+}
+
+func newReader(data []byte, t Type) *reader {
+	r := reader{buf: data}
+	got, err := PeekType(data)
+	if err != nil {
+		r.err = err
+		return &r
+	}
+	if got != t {
+		r.err = ErrWrongType
+		return &r
+	}
+	r.buf = data[HeaderSize:]
+	return &r
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = ErrShortPacket
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) uint8() uint8 {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uint16() uint16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *reader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// done reports the first error, or ErrTrailingData if bytes are left
+func (r *reader) done() error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.buf) != 0 {
+		return ErrTrailingData
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func testSymbolPacket() *SymbolPacket {
+	hash := bytes.Repeat([]byte{0xab}, HashSize)
+	return &SymbolPacket{
+		HashType:          1,
+		RootHash:          hash,
+		Hop:               3,
+		Sender:            bytes.Repeat([]byte{0x5e}, PubKeySize),
+		NumChunks:         4,
+		ChunkID:           2,
+		ChunkSize:         1 << 16,
+		CommonOTI:         0x0102030405060708,
+		SchemeSpecificOTI: 0x01000104,
+		ChunkHash:         bytes.Repeat([]byte{0xcd}, HashSize),
+		Proof:             [][]byte{hash, hash},
+		SymbolID:          77,
+		Symbol:            []byte("symbol payload"),
+		Signature:         bytes.Repeat([]byte{0x51}, SignatureSize),
+	}
+}
+
+func testAckPacket() *AckPacket {
+	return &AckPacket{
+		HashType:    1,
+		RootHash:    bytes.Repeat([]byte{0xab}, HashSize),
+		ChunkID:     2,
+		Received:    40,
+		MaxSymbolID: 45,
+		Peer:        bytes.Repeat([]byte{0x9e}, PubKeySize),
+		Signature:   bytes.Repeat([]byte{0x51}, SignatureSize),
+	}
+}
+
+func mustMarshal(t testing.TB, p interface{ MarshalBinary() ([]byte, error) }) []byte {
+	data, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return data
+}
+
+func FuzzSymbolPacket(f *testing.F) {
+	f.Add(mustMarshal(f, testSymbolPacket()))
+	empty := testSymbolPacket()
+	empty.Proof, empty.Symbol = nil, nil
+	f.Add(mustMarshal(f, empty))
+	f.Add(mustMarshal(f, testAckPacket()))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var p SymbolPacket
+		if err := p.UnmarshalBinary(data); err != nil {
+			return
+		}
+		if p.Size() != len(data) {
+			t.Fatalf("Size() = %v, decoded %v bytes", p.Size(), len(data))
+		}
+		out, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatalf("marshal decoded packet: %v", err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("round trip changed the packet\n got %x\nwant %x", out, data)
+		}
+	})
+}
+
+func FuzzAckPacket(f *testing.F) {
+	f.Add(mustMarshal(f, testAckPacket()))
+	f.Add(mustMarshal(f, testSymbolPacket()))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var p AckPacket
+		if err := p.UnmarshalBinary(data); err != nil {
+			return
+		}
+		out, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatalf("marshal decoded packet: %v", err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("round trip changed the packet\n got %x\nwant %x", out, data)
+		}
+	})
+}
+
+func TestSymbolPacketUnmarshalErrors(t *testing.T) {
+	valid := mustMarshal(t, testSymbolPacket())
+	proofLen := HeaderSize + 1 + HashSize + 1 + PubKeySize + 4 + 4 + 4 + 8 + 4 + HashSize
+	symbolLen := proofLen + 1 + 2*HashSize + 4
+	modified := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), valid...))
+	}
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, ErrShortPacket},
+		{"truncated header", valid[:HeaderSize-1], ErrShortPacket},
+		{"header only", valid[:HeaderSize], ErrShortPacket},
+		{"truncated signature", valid[:len(valid)-1], ErrShortPacket},
+		{"bad magic", modified(func(b []byte) []byte { b[0] = 'X'; return b }), ErrBadMagic},
+		{"wrong version", modified(func(b []byte) []byte { b[2] = Version - 1; return b }), ErrUnsupportedVersion},
+		{"future version", modified(func(b []byte) []byte { b[2] = Version + 1; return b }), ErrUnsupportedVersion},
+		{"ack type", mustMarshal(t, testAckPacket()), ErrWrongType},
+		{"proof length overflow", modified(func(b []byte) []byte { b[proofLen] = byte(MaxProofLen + 1); return b }), ErrInvalidField},
+		{"proof longer than packet", modified(func(b []byte) []byte { b[proofLen] = byte(MaxProofLen); return b }), ErrShortPacket},
+		{"symbol longer than packet", modified(func(b []byte) []byte { b[symbolLen], b[symbolLen+1] = 0xff, 0xff; return b }), ErrShortPacket},
+		{"trailing data", append(append([]byte(nil), valid...), 0), ErrTrailingData},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var p SymbolPacket
+			if err := p.UnmarshalBinary(test.data); !errors.Is(err, test.err) {
+				t.Fatalf("UnmarshalBinary() = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestSymbolPacketMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *SymbolPacket)
+	}{
+		{"oversize symbol", func(p *SymbolPacket) { p.Symbol = make([]byte, 0x10000) }},
+		{"proof too long", func(p *SymbolPacket) {
+			p.Proof = make([][]byte, MaxProofLen+1)
+			for i := range p.Proof {
+				p.Proof[i] = p.RootHash
+			}
+		}},
+		{"short proof hash", func(p *SymbolPacket) { p.Proof[1] = p.Proof[1][:HashSize-1] }},
+		{"short root hash", func(p *SymbolPacket) { p.RootHash = p.RootHash[1:] }},
+		{"short sender", func(p *SymbolPacket) { p.Sender = nil }},
+		{"short chunk hash", func(p *SymbolPacket) { p.ChunkHash = p.ChunkHash[1:] }},
+		{"short signature", func(p *SymbolPacket) { p.Signature = p.Signature[1:] }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := testSymbolPacket()
+			test.modify(p)
+			if _, err := p.MarshalBinary(); !errors.Is(err, ErrInvalidField) {
+				t.Fatalf("MarshalBinary() = %v, want %v", err, ErrInvalidField)
+			}
+		})
+	}
+}
+
+func TestSymbolPacketRoundTrip(t *testing.T) {
+	p := testSymbolPacket()
+	p.Symbol = make([]byte, 0xffff)
+	data := mustMarshal(t, p)
+	if len(data) != p.Size() {
+		t.Fatalf("encoded %v bytes, Size() = %v", len(data), p.Size())
+	}
+	var q SymbolPacket
+	if err := q.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() = %v", err)
+	}
+	if !bytes.Equal(mustMarshal(t, &q), data) {
+		t.Fatal("round trip changed the packet")
+	}
+	// relays rewrite the hop, the signature must still hold
+	q.Hop--
+	signed, err := q.SignedBytes()
+	if err != nil {
+		t.Fatalf("SignedBytes() = %v", err)
+	}
+	want, _ := p.SignedBytes()
+	if !bytes.Equal(signed, want) {
+		t.Fatal("signed bytes depend on the hop")
+	}
+}
+
+func TestAckPacketUnmarshalErrors(t *testing.T) {
+	valid := mustMarshal(t, testAckPacket())
+	if len(valid) != AckPacketSize {
+		t.Fatalf("encoded %v bytes, AckPacketSize is %v", len(valid), AckPacketSize)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"truncated header", valid[:2], ErrShortPacket},
+		{"truncated", valid[:AckPacketSize-1], ErrShortPacket},
+		{"wrong version", append([]byte{'C', 'C', Version - 1}, valid[3:]...), ErrUnsupportedVersion},
+		{"symbol type", mustMarshal(t, testSymbolPacket()), ErrWrongType},
+		{"trailing data", append(append([]byte(nil), valid...), 0), ErrTrailingData},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var p AckPacket
+			if err := p.UnmarshalBinary(test.data); !errors.Is(err, test.err) {
+				t.Fatalf("UnmarshalBinary() = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package manycast
 
-import coopcast "github.com/harmony-one/libunison/internal/ida/coopcast"
+import (
+	coopcast "github.com/harmony-one/libunison/internal/ida/coopcast"
+	"github.com/harmony-one/libunison/internal/ida/transport"
+	"io"
+)
 
 // Node represents a node in the network for manycast
 type Node struct {
@@ -8,10 +12,13 @@ type Node struct {
 	SelfPeer coopcast.Peer
 	PeerList []coopcast.Peer
 	AllPeers []coopcast.Peer
+
+	Transport transport.Transport // TCP if unset
 }
 
 // ManyCast is the interface using manycast to send/receive message
 type ManyCast interface {
 	BroadCast(msg []byte)
+	BroadCastReader(r io.ReaderAt, size int64)
 	ListeningOnUniCast()
 }
@@ -2,18 +2,35 @@ package manycast
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
+	"github.com/harmony-one/libunison/internal/ida/transport"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
+	"os"
 	"strconv"
 	"sync"
 	"time"
 )
 
+// transport returns the transport of the node, TCP if unset
+func (node *Node) transport() transport.Transport {
+	if node.Transport == nil {
+		return transport.Net{}
+	}
+	return node.Transport
+}
+
 // BroadCast let sender broadcast message to peer nodes
 func (node *Node) BroadCast(msg []byte) {
+	node.BroadCastReader(bytes.NewReader(msg), int64(len(msg)))
+}
+
+// BroadCastReader let sender broadcast a message of size bytes read from r to peer nodes,
+// the message is streamed to every peer without being loaded in memory
+func (node *Node) BroadCastReader(r io.ReaderAt, size int64) {
 	var wg sync.WaitGroup
 	t1 := time.Now().UnixNano()
 	for _, peer := range node.AllPeers {
@@ -21,13 +38,13 @@ func (node *Node) BroadCast(msg []byte) {
 			continue
 		}
 		tcpaddr := net.JoinHostPort(peer.IP, peer.TCPPort)
-		conn, err := net.Dial("tcp", tcpaddr)
+		conn, err := node.transport().Dial(context.Background(), tcpaddr)
 		if err != nil {
 			log.Printf("cannot connect to peer %v:%v", peer.IP, peer.TCPPort)
 			continue
 		}
 		wg.Add(1)
-		go sendData(conn, msg, &wg)
+		go sendData(conn, io.NewSectionReader(r, 0, size), size, &wg)
 	}
 	log.Printf("waiting connection to close...")
 	wg.Wait()
@@ -35,16 +52,17 @@ func (node *Node) BroadCast(msg []byte) {
 	log.Printf("finish sending data to all peers with %v ms", (t2-t1)/1000000)
 }
 
-func sendData(conn net.Conn, msg []byte, wg *sync.WaitGroup) {
+func sendData(conn net.Conn, msg io.Reader, size int64, wg *sync.WaitGroup) {
 	defer wg.Done()
 	defer conn.Close()
-	timeoutDuration := 2 * time.Second
-	conn.SetWriteDeadline(time.Now().Add(timeoutDuration))
-	N := len(msg)
+	w := deadlineWriter{conn: conn, timeout: 2 * time.Second}
 	buf := make([]byte, 8)
-	binary.BigEndian.PutUint64(buf, uint64(N))
-	packet := append(buf, msg...)
-	n, err := conn.Write(packet)
+	binary.BigEndian.PutUint64(buf, uint64(size))
+	if _, err := w.Write(buf); err != nil {
+		log.Printf("cannot unicast data to peer %v", conn.RemoteAddr())
+		return
+	}
+	n, err := io.Copy(w, msg)
 	if err != nil {
 		log.Printf("cannot unicast data to peer %v", conn.RemoteAddr())
 		return
@@ -52,10 +70,21 @@ func sendData(conn net.Conn, msg []byte, wg *sync.WaitGroup) {
 	log.Printf("%v bytes write", n)
 }
 
+// deadlineWriter fails a write which makes no progress during timeout, however long the whole message takes
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.conn.Write(p)
+}
+
 // ListeningOnUniCast let receiver listening and receive message from the sender
 func (node *Node) ListeningOnUniCast() {
 	addr := net.JoinHostPort("127.0.0.1", node.SelfPeer.TCPPort)
-	ln, err := net.Listen("tcp", addr)
+	ln, err := node.transport().Listen(addr)
 	if err != nil {
 		log.Printf("cannot listening to the port %s", node.SelfPeer.TCPPort)
 		return
@@ -82,13 +111,17 @@ func (node *Node) handleData(conn net.Conn) {
 	if err != nil {
 		log.Printf("error get filesize, get %v", n)
 	}
-	N := int(binary.BigEndian.Uint64(size))
-	content := make([]byte, N)
-	_, err = io.ReadFull(c, content)
+	N := int64(binary.BigEndian.Uint64(size))
+	fileloc := "received/" + strconv.FormatUint(uint64(time.Now().UnixNano()), 10)
+	file, err := os.Create(fileloc)
+	if err != nil {
+		log.Printf("cannot create file %v", fileloc)
+		return
+	}
+	defer file.Close()
+	_, err = io.CopyN(file, c, N)
 	if err != nil {
 		log.Printf("cannot read full file")
 	}
-	fileloc := "received/" + strconv.FormatUint(uint64(time.Now().UnixNano()), 10)
-	ioutil.WriteFile(fileloc, content, 0644)
 	log.Printf("%v received file written to disk", node.SelfPeer.Sid)
 }
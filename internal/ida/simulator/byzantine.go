@@ -0,0 +1,359 @@
+package simulator
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/harmony-one/libunison/internal/ida/coopcast"
+	"github.com/harmony-one/libunison/internal/ida/coopcast/wire"
+	"github.com/harmony-one/libunison/internal/ida/transport"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaults of the adversaries
+const (
+	defaultForgedCopies   int           = 3
+	defaultReplayDelay    time.Duration = 2 * time.Second
+	defaultReplayInterval time.Duration = 5 * time.Second
+	maxReplayedPackets    int           = 1024
+	defaultFloodRate      int           = 200 // packets per second
+	floodSymbolSize       uint16        = 1200
+	floodChunkSize        uint32        = 100 * uint32(floodSymbolSize)
+)
+
+var errMuted = errors.New("byzantine node does not open streams")
+
+// Env is what an adversary knows about the simulation
+type Env struct {
+	Self      int
+	Sender    int
+	Peers     []coopcast.Peer // every node of the simulation, indexed by node
+	Neighbors []int
+	Key       ed25519.PrivateKey
+	Transport transport.Transport // network of the simulation
+	Conn      net.PacketConn      // datagram endpoint of the node on the network
+}
+
+// neighborAddrs resolves the datagram addresses of the neighbors
+func (env *Env) neighborAddrs() []net.Addr {
+	var addrs []net.Addr
+	for _, i := range env.Neighbors {
+		addr, err := env.Transport.ResolveAddr(net.JoinHostPort(env.Peers[i].IP, env.Peers[i].UDPPort))
+		if err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Byzantine is an adversary attached to a node
+type Byzantine struct {
+	Transport transport.Transport       // used by the coopcast node of the adversary in place of Env.Transport
+	Conn      net.PacketConn            // used by the coopcast node of the adversary in place of Env.Conn
+	Attack    func(ctx context.Context) // runs from the start of the broadcast until ctx is done, may be nil
+}
+
+// Adversary makes a byzantine node out of a coopcast node. The node still runs the real protocol, but
+// over endpoints which may drop, alter or record its traffic, and next to an attack of its own.
+type Adversary interface {
+	// Attach is called for every byzantine node before the node starts
+	Attach(env *Env) Byzantine
+}
+
+// ParseAdversary returns the adversary of the given name with its default parameters,
+// one of drop, corrupt, forge, replay and flood
+func ParseAdversary(name string) (Adversary, error) {
+	switch name {
+	case "drop":
+		return DropAll{}, nil
+	case "corrupt":
+		return CorruptRelays{}, nil
+	case "forge":
+		return ForgeAcks{}, nil
+	case "replay":
+		return Replay{}, nil
+	case "flood":
+		return FloodRoots{}, nil
+	}
+	return nil, fmt.Errorf("unknown adversary %q, [drop|corrupt|forge|replay|flood]", name)
+}
+
+// filterConn passes the datagrams read by a node to read and the ones it writes through write,
+// write returns the datagram actually sent or nil to drop it
+type filterConn struct {
+	net.PacketConn
+	read  func(b []byte, addr net.Addr)
+	write func(b []byte) []byte
+}
+
+func (c *filterConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil && c.read != nil {
+		c.read(b[:n], addr)
+	}
+	return n, addr, err
+}
+
+func (c *filterConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.write == nil {
+		return c.PacketConn.WriteTo(b, addr)
+	}
+	out := c.write(b)
+	if out == nil {
+		return len(b), nil
+	}
+	if _, err := c.PacketConn.WriteTo(out, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// isSymbol tells whether a datagram is a symbol packet
+func isSymbol(b []byte) bool {
+	t, err := wire.PeekType(b)
+	return err == nil && t == wire.TypeSymbol
+}
+
+// muted is a transport on which no stream can be opened, the acknowledgements of the node are lost
+type muted struct {
+	transport.Transport
+}
+
+func (muted) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	return nil, errMuted
+}
+
+// DropAll decodes the messages but neither relays symbols nor acknowledges chunks
+type DropAll struct{}
+
+// Attach drops every datagram and stream of the node
+func (DropAll) Attach(env *Env) Byzantine {
+	conn := &filterConn{PacketConn: env.Conn, write: func(b []byte) []byte { return nil }}
+	return Byzantine{Transport: muted{env.Transport}, Conn: conn}
+}
+
+// CorruptRelays relays every symbol with its payload altered, receivers have to reject them
+// before they reach a decoder
+type CorruptRelays struct{}
+
+// Attach flips the last byte of the symbol of every packet written by the node
+func (CorruptRelays) Attach(env *Env) Byzantine {
+	conn := &filterConn{PacketConn: env.Conn, write: func(b []byte) []byte {
+		if !isSymbol(b) || len(b) < wire.MinSymbolPacketSize {
+			return b
+		}
+		out := append([]byte(nil), b...)
+		out[len(out)-wire.SignatureSize-1] ^= 0xff
+		return out
+	}}
+	return Byzantine{Transport: env.Transport, Conn: conn}
+}
+
+// ForgeAcks acknowledges every chunk of every message it receives in the name of every peer, as soon as
+// the first symbol arrives, so that a sender trusting the acknowledgements stops before the message spread.
+// The acknowledgements are signed with the key of the node, only its own ones can pass for genuine.
+type ForgeAcks struct {
+	Copies int // acknowledgements sent per peer and chunk, 3 if unset
+}
+
+// Attach forges the acknowledgements of the messages seen by the node
+func (forge ForgeAcks) Attach(env *Env) Byzantine {
+	copies := forge.Copies
+	if copies <= 0 {
+		copies = defaultForgedCopies
+	}
+	var mux sync.Mutex
+	seen := make(map[string]bool)
+	messages := make(chan wire.SymbolPacket, 16)
+	conn := &filterConn{PacketConn: env.Conn, read: func(b []byte, addr net.Addr) {
+		var packet wire.SymbolPacket
+		if !isSymbol(b) || packet.UnmarshalBinary(b) != nil {
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		if seen[string(packet.RootHash)] {
+			return
+		}
+		seen[string(packet.RootHash)] = true
+		packet.RootHash = append([]byte(nil), packet.RootHash...)
+		packet.Sender = append([]byte(nil), packet.Sender...)
+		select {
+		case messages <- packet:
+		default:
+		}
+	}}
+	attack := func(ctx context.Context) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case packet := <-messages:
+				forge.forge(ctx, env, packet, copies)
+			}
+		}
+	}
+	return Byzantine{Transport: env.Transport, Conn: conn, Attack: attack}
+}
+
+// forge sends the acknowledgements of every chunk of a message to its sender
+func (forge ForgeAcks) forge(ctx context.Context, env *Env, packet wire.SymbolPacket, copies int) {
+	var sender *coopcast.Peer
+	for i := range env.Peers {
+		key, err := coopcast.ParsePubKey(env.Peers[i].PubKey)
+		if err == nil && key.Equal(ed25519.PublicKey(packet.Sender)) {
+			sender = &env.Peers[i]
+		}
+	}
+	if sender == nil {
+		return
+	}
+	addr := net.JoinHostPort(sender.IP, sender.TCPPort)
+	for z := uint32(0); z < packet.NumChunks; z++ {
+		for _, peer := range env.Peers {
+			key, err := coopcast.ParsePubKey(peer.PubKey)
+			if err != nil {
+				continue
+			}
+			ack := wire.AckPacket{HashType: packet.HashType, RootHash: packet.RootHash, ChunkID: z, Peer: key}
+			signed, err := ack.SignedBytes()
+			if err != nil {
+				continue
+			}
+			ack.Signature = ed25519.Sign(env.Key, signed)
+			msg, err := ack.MarshalBinary()
+			if err != nil {
+				continue
+			}
+			for i := 0; i < copies; i++ {
+				if ctx.Err() != nil {
+					return
+				}
+				conn, err := env.Transport.Dial(ctx, addr)
+				if err != nil {
+					log.Printf("forged ack to %v failed: %v", addr, err)
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(time.Second))
+				conn.Write(msg)
+				conn.Close()
+			}
+		}
+	}
+}
+
+// Replay records the symbols the node receives and sends them again to its neighbors long after,
+// receivers must neither decode nor deliver a message twice
+type Replay struct {
+	Delay    time.Duration // time after the start of the broadcast before the first replay, 2 seconds if unset
+	Interval time.Duration // time between replays, 5 seconds if unset
+}
+
+// Attach records the first symbols received by the node and replays them until the end of the simulation
+func (replay Replay) Attach(env *Env) Byzantine {
+	delay, interval := replay.Delay, replay.Interval
+	if delay <= 0 {
+		delay = defaultReplayDelay
+	}
+	if interval <= 0 {
+		interval = defaultReplayInterval
+	}
+	var mux sync.Mutex
+	var recorded [][]byte
+	conn := &filterConn{PacketConn: env.Conn, read: func(b []byte, addr net.Addr) {
+		if !isSymbol(b) {
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		if len(recorded) < maxReplayedPackets {
+			recorded = append(recorded, append([]byte(nil), b...))
+		}
+	}}
+	attack := func(ctx context.Context) {
+		addrs := env.neighborAddrs()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			mux.Lock()
+			packets := recorded
+			mux.Unlock()
+			for _, packet := range packets {
+				for _, addr := range addrs {
+					env.Conn.WriteTo(packet, addr)
+				}
+			}
+			timer.Reset(interval)
+		}
+	}
+	return Byzantine{Transport: env.Transport, Conn: conn, Attack: attack}
+}
+
+// FloodRoots sends its neighbors symbols of ever new messages, signed with its own key like any member
+// of the network can. Receivers have to bound the memory of these sessions and keep decoding the real ones.
+type FloodRoots struct {
+	Rate int // packets per second, 200 if unset
+}
+
+// Attach floods the neighbors of the node with the symbols of random single chunk messages
+func (flood FloodRoots) Attach(env *Env) Byzantine {
+	rate := flood.Rate
+	if rate <= 0 {
+		rate = defaultFloodRate
+	}
+	attack := func(ctx context.Context) {
+		addrs := env.neighborAddrs()
+		rng := rand.New(rand.NewSource(int64(env.Self)))
+		sender := env.Key.Public().(ed25519.PublicKey)
+		ticker := time.NewTicker(time.Second / time.Duration(rate))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			root := make([]byte, wire.HashSize)
+			rng.Read(root)
+			symbol := make([]byte, floodSymbolSize)
+			rng.Read(symbol)
+			// a single chunk message, its root is the hash of the chunk and the merkle proof is empty
+			packet := wire.SymbolPacket{
+				HashType:          byte(coopcast.SHA256),
+				RootHash:          root,
+				Hop:               0, // not relayed, the flood stays in the neighborhood of the node
+				Sender:            sender,
+				NumChunks:         1,
+				ChunkSize:         floodChunkSize,
+				CommonOTI:         uint64(floodChunkSize)<<24 | uint64(floodSymbolSize),
+				SchemeSpecificOTI: 1<<24 | 1<<8 | 4,
+				ChunkHash:         root,
+				SymbolID:          rng.Uint32(),
+				Symbol:            symbol,
+			}
+			signed, err := packet.SignedBytes()
+			if err != nil {
+				continue
+			}
+			packet.Signature = ed25519.Sign(env.Key, signed)
+			b, err := packet.MarshalBinary()
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				env.Conn.WriteTo(b, addr)
+			}
+		}
+	}
+	return Byzantine{Transport: env.Transport, Conn: env.Conn, Attack: attack}
+}
@@ -0,0 +1,58 @@
+package simulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ReadGraph reads a topology in the format of the graphN.txt files of cmd/broadcast: the first line is the
+// number of nodes, every other line lists a node followed by its neighbors. It returns the neighbors of every node.
+func ReadGraph(r io.Reader) ([][]int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("empty graph")
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid number of nodes %q", scanner.Text())
+	}
+	graph := make([][]int, n)
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		ids := make([]int, len(fields))
+		for i, field := range fields {
+			id, err := strconv.Atoi(field)
+			if err != nil || id < 0 || id >= n {
+				return nil, fmt.Errorf("line %v: invalid node %q", line, field)
+			}
+			ids[i] = id
+		}
+		for _, id := range ids[1:] {
+			if id != ids[0] {
+				graph[ids[0]] = append(graph[ids[0]], id)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// ReadGraphFile reads a topology from a graphN.txt file
+func ReadGraphFile(path string) ([][]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadGraph(file)
+}
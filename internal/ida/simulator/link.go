@@ -0,0 +1,131 @@
+package simulator
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// default queue of a link with a bandwidth cap
+const defaultMaxQueueDelay time.Duration = time.Second
+
+// Distribution is the shape of the latency of a link
+type Distribution int
+
+// latency distributions
+const (
+	Constant    Distribution = iota // always Latency
+	Uniform                         // uniform between Latency-Jitter and Latency+Jitter
+	Normal                          // normal of mean Latency and standard deviation Jitter
+	Exponential                     // Latency plus an exponential of mean Jitter, a long tail of late datagrams
+)
+
+// LinkModel describes a directed link between two nodes
+type LinkModel struct {
+	Latency       time.Duration
+	Jitter        time.Duration
+	Distribution  Distribution
+	LossRate      float64       // probability that a datagram is lost, between 0 and 1
+	Bandwidth     int64         // bytes per second, unlimited if 0
+	MaxQueueDelay time.Duration // datagrams waiting longer behind the bandwidth cap are dropped, 1 second if unset
+}
+
+// latency draws the propagation delay of a datagram
+func (model LinkModel) latency(rng *rand.Rand) time.Duration {
+	var d time.Duration
+	switch model.Distribution {
+	case Uniform:
+		d = model.Latency - model.Jitter + time.Duration(rng.Int63n(int64(2*model.Jitter)+1))
+	case Normal:
+		d = model.Latency + time.Duration(rng.NormFloat64()*float64(model.Jitter))
+	case Exponential:
+		d = model.Latency + time.Duration(rng.ExpFloat64()*float64(model.Jitter))
+	default:
+		d = model.Latency
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Link identifies the directed link from a node to one of its neighbors
+type Link struct {
+	From, To int
+}
+
+// links implements transport.Conditioner for the nodes of a simulation and counts their traffic
+type links struct {
+	mux      sync.Mutex
+	rng      *rand.Rand
+	nodes    map[string]int // datagram address to node
+	model    LinkModel
+	models   map[Link]LinkModel
+	busy     map[Link]time.Time // end of the transmission of the last datagram queued on a link
+	sent     []int64
+	received []int64
+}
+
+func newLinks(seed int64, addrs []string, model LinkModel, models map[Link]LinkModel) *links {
+	l := &links{
+		rng:      rand.New(rand.NewSource(seed)),
+		nodes:    make(map[string]int),
+		model:    model,
+		models:   models,
+		busy:     make(map[Link]time.Time),
+		sent:     make([]int64, len(addrs)),
+		received: make([]int64, len(addrs)),
+	}
+	for i, addr := range addrs {
+		l.nodes[addr] = i
+	}
+	return l
+}
+
+// Datagram applies the loss, the bandwidth cap and the latency of the link between src and dst
+func (l *links) Datagram(src, dst string, size int) (time.Duration, bool) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	from, ok := l.nodes[src]
+	to, ok2 := l.nodes[dst]
+	if !ok || !ok2 {
+		return 0, true
+	}
+	l.sent[from] += int64(size)
+	link := Link{From: from, To: to}
+	model, ok := l.models[link]
+	if !ok {
+		model = l.model
+	}
+	if model.LossRate > 0 && l.rng.Float64() < model.LossRate {
+		return 0, true
+	}
+	var queued time.Duration
+	if model.Bandwidth > 0 {
+		now := time.Now()
+		start := l.busy[link]
+		if start.Before(now) {
+			start = now
+		}
+		queued = start.Sub(now)
+		maxQueue := model.MaxQueueDelay
+		if maxQueue <= 0 {
+			maxQueue = defaultMaxQueueDelay
+		}
+		if queued > maxQueue {
+			return 0, true
+		}
+		transmission := time.Duration(int64(size) * int64(time.Second) / model.Bandwidth)
+		l.busy[link] = start.Add(transmission)
+		queued += transmission
+	}
+	l.received[to] += int64(size)
+	return queued + model.latency(l.rng), false
+}
+
+// traffic returns the bytes sent and received by every node
+func (l *links) traffic() ([]int64, []int64) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return append([]int64(nil), l.sent...), append([]int64(nil), l.received...)
+}
@@ -0,0 +1,345 @@
+// Package simulator runs coopcast nodes inside a single process over an in-memory network whose links
+// have configurable latency, loss and bandwidth, so that the sender and relay parameters can be tuned
+// under realistic conditions without deploying real nodes. Byzantine nodes, see Adversary, check that the
+// honest nodes still deliver the message when some nodes misbehave.
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/harmony-one/libunison/internal/ida/coopcast"
+	"github.com/harmony-one/libunison/internal/ida/transport"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ports of the simulated nodes, node i listens on udpBasePort+i and tcpBasePort+i like cmd/broadcast configs
+const (
+	udpBasePort    int           = 10000
+	tcpBasePort    int           = 20000
+	defaultTimeout time.Duration = 60 * time.Second
+	// the decode of a chunk is recorded right after its acknowledgement leaves, and the broadcast starts
+	// a little before the time origin of the sender, decodes this late still count for a stop
+	stopSlack time.Duration = 10 * time.Millisecond
+)
+
+var errInvalidSender = errors.New("sender is not a node of the graph")
+
+// Config describes a simulation: a topology, the links between the nodes and the message broadcast
+type Config struct {
+	Graph       [][]int // neighbors of every node, see ReadGraph
+	Sender      int     // node broadcasting the message
+	MessageSize int
+	Link        LinkModel             // model of every link
+	Links       map[Link]LinkModel    // links which do not follow Link
+	Crashes     map[int]time.Duration // nodes stopping at the given time after the broadcast starts
+	Seed        int64                 // seeds the message, the keys, the losses and the latencies
+	Timeout     time.Duration         // the simulation stops after it, 60 seconds if unset
+	Adversaries map[int]Adversary     // byzantine nodes, the sender is always honest
+
+	// Node is the template of the options of every node, the simulator fills the peers, the key and
+	// the transport. The delay parameters of the sender and the relays are usually set here.
+	Node coopcast.Options
+}
+
+// NodeReport is the outcome of a simulation for a node
+type NodeReport struct {
+	Delivered     bool
+	Deliveries    int           // times the message was delivered, more than once if a replay fooled the node
+	Elapsed       time.Duration // time from the start of the broadcast to the delivery of the message
+	Crashed       bool
+	Byzantine     bool
+	BytesSent     int64            // datagrams written by the node, the lost ones included
+	BytesReceived int64            // datagrams sent to the node which were not lost on the way
+	Traffic       coopcast.Traffic // symbols of the messages of the sender accounted by the node
+}
+
+// Report is the outcome of a simulation
+type Report struct {
+	Sender         int
+	Complete       bool          // every honest node which did not crash delivered the message
+	CompletionTime time.Duration // time from the start of the broadcast to the last delivery
+	Nodes          []NodeReport
+	Threshold      int   // nodes which must decode a chunk before the sender stops broadcasting it
+	EarlyChunks    []int // chunks the sender stopped broadcasting before Threshold nodes decoded them
+}
+
+// Check returns an error if an honest node which did not crash missed the message or delivered it more
+// than once, or if the sender was tricked into stopping the broadcast of a chunk too early
+func (r *Report) Check() error {
+	var missed, duplicated []int
+	for i, node := range r.Nodes {
+		if i == r.Sender || node.Byzantine || node.Crashed {
+			continue
+		}
+		if !node.Delivered {
+			missed = append(missed, i)
+		}
+		if node.Deliveries > 1 {
+			duplicated = append(duplicated, i)
+		}
+	}
+	switch {
+	case len(r.EarlyChunks) > 0:
+		return fmt.Errorf("sender stopped broadcasting chunks %v before %v nodes decoded them", r.EarlyChunks, r.Threshold)
+	case len(missed) > 0:
+		return fmt.Errorf("honest nodes %v did not deliver the message", missed)
+	case len(duplicated) > 0:
+		return fmt.Errorf("honest nodes %v delivered the message more than once", duplicated)
+	}
+	return nil
+}
+
+// String formats the report as a table of nodes
+func (r *Report) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "complete=%v completion=%v early_chunks=%v\n", r.Complete, r.CompletionTime, r.EarlyChunks)
+	fmt.Fprintf(&buf, "%5s %9s %14s %12s %12s %8s\n", "node", "delivered", "elapsed", "sent", "received", "ratio")
+	for i, node := range r.Nodes {
+		state := strconv.FormatBool(node.Delivered)
+		switch {
+		case i == r.Sender:
+			state = "sender"
+		case node.Crashed:
+			state = "crashed"
+		case node.Byzantine:
+			state = "byzantine"
+		}
+		fmt.Fprintf(&buf, "%5d %9s %14v %12d %12d %8.2f\n", i, state, node.Elapsed, node.BytesSent, node.BytesReceived, node.Traffic.Ratio())
+	}
+	return buf.String()
+}
+
+// simNode is a coopcast node of a simulation
+type simNode struct {
+	node      *coopcast.Node
+	pc        net.PacketConn
+	closeOnce sync.Once
+}
+
+func (n *simNode) close() {
+	n.closeOnce.Do(func() {
+		n.node.Close()
+		n.pc.Close()
+	})
+}
+
+// Run broadcasts a random message from the sender and waits until every node which did not crash
+// delivered it, the timeout expired or ctx is done
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	n := len(cfg.Graph)
+	if cfg.Sender < 0 || cfg.Sender >= n {
+		return nil, errInvalidSender
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	rng := rand.New(rand.NewSource(cfg.Seed))
+
+	peers := make([]coopcast.Peer, n)
+	keys := make([]ed25519.PrivateKey, n)
+	addrs := make([]string, n)
+	for i := range peers {
+		pub, priv, err := ed25519.GenerateKey(rng)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = priv
+		peers[i] = coopcast.Peer{IP: "127.0.0.1", TCPPort: strconv.Itoa(tcpBasePort + i), UDPPort: strconv.Itoa(udpBasePort + i), PubKey: hex.EncodeToString(pub), Sid: i}
+		addrs[i] = net.JoinHostPort(peers[i].IP, peers[i].UDPPort)
+	}
+	network := transport.NewMemoryNetwork()
+	links := newLinks(rng.Int63(), addrs, cfg.Link, cfg.Links)
+	network.Conditioner = links
+
+	// every node delivers the message or crashes at most once
+	delivered := make(chan int, 2*n)
+	var mux sync.Mutex
+	elapsed := make([]time.Duration, n)
+	deliveries := make([]int, n)
+	decodes := make([]map[int]time.Duration, n) // decode time of the chunks by every node
+	crashed := make([]bool, n)
+	var start time.Time
+	msg := make([]byte, cfg.MessageSize)
+	rng.Read(msg)
+
+	nodes := make([]*simNode, n)
+	defer func() {
+		for _, node := range nodes {
+			if node != nil {
+				node.close()
+			}
+		}
+	}()
+	var attacks []func(ctx context.Context)
+	for i := range nodes {
+		pc, err := network.ListenPacket(addrs[i])
+		if err != nil {
+			return nil, err
+		}
+		opts := cfg.Node
+		opts.SelfPeer = peers[i]
+		opts.AllPeers = peers
+		opts.PrivKey = keys[i]
+		opts.Transport = network
+		opts.PeerList = nil
+		for _, j := range cfg.Graph[i] {
+			opts.PeerList = append(opts.PeerList, peers[j])
+		}
+		conn := pc
+		if adversary, ok := cfg.Adversaries[i]; ok && i != cfg.Sender {
+			env := &Env{Self: i, Sender: cfg.Sender, Peers: peers, Neighbors: cfg.Graph[i], Key: keys[i], Transport: network, Conn: pc}
+			byzantine := adversary.Attach(env)
+			opts.Transport, conn = byzantine.Transport, byzantine.Conn
+			if byzantine.Attack != nil {
+				attacks = append(attacks, byzantine.Attack)
+			}
+		}
+		node := coopcast.NewNode(opts)
+		i := i
+		decodes[i] = make(map[int]time.Duration)
+		node.OnChunk(func(c coopcast.Chunk) {
+			mux.Lock()
+			defer mux.Unlock()
+			if _, ok := decodes[i][c.ChunkID]; !ok {
+				decodes[i][c.ChunkID] = time.Since(start)
+			}
+		})
+		node.OnMessage(func(m coopcast.Message) {
+			if !bytes.Equal(m.Payload, msg) {
+				return
+			}
+			mux.Lock()
+			deliveries[i]++
+			first := deliveries[i] == 1
+			if first {
+				elapsed[i] = time.Since(start)
+			}
+			mux.Unlock()
+			if first {
+				delivered <- i
+			}
+		})
+		nodes[i] = &simNode{node: node, pc: pc}
+		if err := node.Start(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	mux.Lock()
+	start = time.Now()
+	mux.Unlock()
+	for i, at := range cfg.Crashes {
+		if i < 0 || i >= n || i == cfg.Sender {
+			continue
+		}
+		i := i
+		timer := time.AfterFunc(at, func() {
+			mux.Lock()
+			crashed[i] = true
+			mux.Unlock()
+			nodes[i].close()
+			delivered <- -1
+		})
+		defer timer.Stop()
+	}
+	attackCtx, stopAttacks := context.WithCancel(ctx)
+	var attackers sync.WaitGroup
+	defer attackers.Wait()
+	defer stopAttacks()
+	for _, attack := range attacks {
+		attack := attack
+		attackers.Add(1)
+		go func() {
+			defer attackers.Done()
+			attack(attackCtx)
+		}()
+	}
+	handle, err := nodes[cfg.Sender].node.BroadCastWith(msg, nodes[cfg.Sender].pc, coopcast.BroadCastOptions{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Cancel()
+
+	report := &Report{Sender: cfg.Sender, Nodes: make([]NodeReport, n)}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	done := make([]bool, n)
+wait:
+	for {
+		mux.Lock()
+		report.Complete = true
+		for i := range done {
+			if i != cfg.Sender && !done[i] && !crashed[i] && cfg.Adversaries[i] == nil {
+				report.Complete = false
+			}
+		}
+		mux.Unlock()
+		if report.Complete {
+			break
+		}
+		select {
+		case i := <-delivered:
+			if i >= 0 {
+				done[i] = true
+			}
+		case <-deadline.C:
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	stopAttacks()
+	for _, node := range nodes {
+		node.close()
+	}
+	stats := handle.Stats()
+	sent, received := links.traffic()
+	var sender coopcast.PeerID
+	copy(sender[:], keys[cfg.Sender].Public().(ed25519.PublicKey))
+	mux.Lock()
+	defer mux.Unlock()
+	for i := range report.Nodes {
+		report.Nodes[i] = NodeReport{Delivered: done[i], Deliveries: deliveries[i], Crashed: crashed[i], BytesSent: sent[i], BytesReceived: received[i]}
+		report.Nodes[i].Byzantine = i != cfg.Sender && cfg.Adversaries[i] != nil
+		for key, traffic := range nodes[i].node.Traffic() {
+			if key.Sender == sender {
+				report.Nodes[i].Traffic.Upload += traffic.Upload
+				report.Nodes[i].Traffic.Download += traffic.Download
+				report.Nodes[i].Traffic.Dropped += traffic.Dropped
+			}
+		}
+		if done[i] {
+			report.Nodes[i].Elapsed = elapsed[i]
+			if elapsed[i] > report.CompletionTime {
+				report.CompletionTime = elapsed[i]
+			}
+		}
+	}
+	report.Threshold = stats.Threshold
+	for z := 0; z < stats.NumChunks; z++ {
+		stopped, ok := stats.FinishedChunks[z]
+		if !ok {
+			continue
+		}
+		var decoded int
+		for i := range decodes {
+			if t, ok := decodes[i][z]; ok && i != cfg.Sender && t <= stopped+stopSlack {
+				decoded++
+			}
+		}
+		if decoded < stats.Threshold {
+			report.EarlyChunks = append(report.EarlyChunks, z)
+		}
+	}
+	return report, nil
+}
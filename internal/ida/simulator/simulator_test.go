@@ -0,0 +1,144 @@
+package simulator
+
+import (
+	"context"
+	"github.com/harmony-one/libunison/internal/ida/coopcast"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// completeGraph returns the graph of n nodes which are all neighbors
+func completeGraph(n int) [][]int {
+	graph := make([][]int, n)
+	for i := range graph {
+		for j := 0; j < n; j++ {
+			if j != i {
+				graph[i] = append(graph[i], j)
+			}
+		}
+	}
+	return graph
+}
+
+// TestReadGraph checks the parsing of the graphN.txt topologies
+func TestReadGraph(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		graph [][]int
+		valid bool
+	}{
+		{"line", "3\n0 1\n1 0 2\n2 1\n", [][]int{{1}, {0, 2}, {1}}, true},
+		{"blank lines and self loops", "2\n\n0 0 1\n\n1 0\n", [][]int{{1}, {0}}, true},
+		{"isolated node", "2\n0\n", [][]int{nil, nil}, true},
+		{"empty", "", nil, false},
+		{"no nodes", "0\n", nil, false},
+		{"invalid size", "two\n", nil, false},
+		{"node out of range", "2\n0 2\n", nil, false},
+		{"negative node", "2\n0 -1\n", nil, false},
+		{"invalid node", "2\n0 one\n", nil, false},
+	}
+	for _, test := range tests {
+		graph, err := ReadGraph(strings.NewReader(test.input))
+		if (err == nil) != test.valid {
+			t.Errorf("%v: ReadGraph() = %v, want valid %v", test.name, err, test.valid)
+			continue
+		}
+		if test.valid && !reflect.DeepEqual(graph, test.graph) {
+			t.Errorf("%v: graph %v, want %v", test.name, graph, test.graph)
+		}
+	}
+}
+
+// TestRunAdversaries checks that the honest nodes deliver the message exactly once, and that the sender does
+// not stop too early, when some nodes crash or misbehave
+func TestRunAdversaries(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	tests := []struct {
+		name        string
+		adversaries map[int]Adversary
+		crashes     map[int]time.Duration
+	}{
+		{"honest", nil, nil},
+		{"drop", map[int]Adversary{3: DropAll{}, 5: DropAll{}}, nil},
+		{"corrupt", map[int]Adversary{3: CorruptRelays{}, 5: CorruptRelays{}}, nil},
+		{"crash", nil, map[int]time.Duration{2: 0, 4: 20 * time.Millisecond}},
+		{"forge", map[int]Adversary{3: ForgeAcks{}}, nil},
+		{"replay", map[int]Adversary{3: Replay{}}, nil},
+		{"flood", map[int]Adversary{3: FloodRoots{}}, nil},
+		{"mixed", map[int]Adversary{3: CorruptRelays{}, 5: ForgeAcks{}}, map[int]time.Duration{2: 10 * time.Millisecond}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			cfg := Config{
+				Graph:       completeGraph(8),
+				MessageSize: 100 << 10,
+				Link:        LinkModel{Latency: 5 * time.Millisecond, Jitter: time.Millisecond},
+				Crashes:     test.crashes,
+				Adversaries: test.adversaries,
+				Seed:        7,
+				Timeout:     30 * time.Second,
+			}
+			cfg.Node.InitialDelayTime = 5
+			cfg.Node.MaxDelayTime = 50
+			cfg.Node.ExpBase = 1.05
+			cfg.Node.RelayTime = 7
+			cfg.Node.Hop = 1
+			report, err := Run(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("Run() = %v", err)
+			}
+			if err := report.Check(); err != nil {
+				t.Errorf("%v\n%v", err, report)
+			}
+		})
+	}
+}
+
+// BenchmarkPacingStrategies broadcasts a message over links of limited bandwidth with every pacer of the sender and reports
+// how long the nodes take to deliver it and how many bytes the sender spends
+func BenchmarkPacingStrategies(b *testing.B) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	strategies := []coopcast.PacingStrategy{coopcast.PaceExponential, coopcast.PaceConstant, coopcast.PaceLinear, coopcast.PaceTokenBucket, coopcast.PaceAdaptive}
+	for _, strategy := range strategies {
+		strategy := strategy
+		b.Run(strategy.String(), func(b *testing.B) {
+			var completion time.Duration
+			var sent int64
+			for i := 0; i < b.N; i++ {
+				cfg := Config{
+					Graph:       completeGraph(8),
+					MessageSize: 100 << 10,
+					Link:        LinkModel{Latency: 5 * time.Millisecond, Jitter: 2 * time.Millisecond, Distribution: Uniform, Bandwidth: 4 << 20},
+					Seed:        int64(i),
+					Timeout:     30 * time.Second,
+				}
+				cfg.Node.InitialDelayTime = 5
+				cfg.Node.MaxDelayTime = 50
+				cfg.Node.ExpBase = 1.05
+				cfg.Node.RelayTime = 7
+				cfg.Node.Hop = 1
+				cfg.Node.Pacing = coopcast.PacingOptions{Strategy: strategy, Rate: 400}
+				report, err := Run(context.Background(), cfg)
+				if err != nil {
+					b.Fatalf("Run() = %v", err)
+				}
+				if !report.Complete {
+					b.Fatalf("simulation not complete\n%v", report)
+				}
+				completion += report.CompletionTime
+				sent += report.Nodes[cfg.Sender].BytesSent
+			}
+			b.ReportMetric(float64(completion.Milliseconds())/float64(b.N), "completion-ms")
+			b.ReportMetric(float64(sent)/float64(b.N), "sender-bytes")
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memQueueSize is the number of datagrams buffered by a memory endpoint before new ones are dropped
+const memQueueSize int = 4096
+
+var (
+	errAddrInUse       = errors.New("address already in use")
+	errConnRefused     = errors.New("connection refused")
+	errInvalidAddrType = errors.New("address is not a memory address")
+)
+
+// MemoryNetwork is a Transport connecting the endpoints opened on it inside the process.
+// Datagrams are copied to a bounded queue of their destination and, like UDP, silently dropped
+// when nobody listens at the destination or its queue is full. Streams are synchronous pipes.
+// An endpoint bound to a host-less address such as ":9000" receives for every host on that port.
+type MemoryNetwork struct {
+	// Conditioner delays or drops the datagrams, they are delivered at once if nil. It is set before the
+	// first datagram is written.
+	Conditioner Conditioner
+
+	mux       sync.Mutex
+	packets   map[string]*memPacketConn
+	listeners map[string]*memListener
+	nextPort  int // ephemeral port of the next dialed stream
+}
+
+var _ Transport = (*MemoryNetwork)(nil)
+
+// Conditioner models the links of a MemoryNetwork
+type Conditioner interface {
+	// Datagram returns the delay after which a datagram of size bytes sent by src reaches dst, or drop
+	// to lose it. src is the address the sender listens at and dst the address it writes to.
+	Datagram(src, dst string, size int) (delay time.Duration, drop bool)
+}
+
+// NewMemoryNetwork creates an empty in-memory network
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		packets:   make(map[string]*memPacketConn),
+		listeners: make(map[string]*memListener),
+		nextPort:  49152,
+	}
+}
+
+// memAddr is the address of a memory endpoint
+type memAddr string
+
+func (a memAddr) Network() string { return "memory" }
+func (a memAddr) String() string  { return string(a) }
+
+// wildcard returns the host-less form of addr, which matches every host on the port of addr
+func wildcard(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return net.JoinHostPort("", port)
+}
+
+// ListenPacket opens a datagram endpoint at addr
+func (network *MemoryNetwork) ListenPacket(addr string) (net.PacketConn, error) {
+	network.mux.Lock()
+	defer network.mux.Unlock()
+	if _, ok := network.packets[addr]; ok {
+		return nil, &net.OpError{Op: "listen", Net: "memory", Addr: memAddr(addr), Err: errAddrInUse}
+	}
+	pc := &memPacketConn{
+		network:  network,
+		addr:     memAddr(addr),
+		queue:    make(chan datagram, memQueueSize),
+		closed:   make(chan struct{}),
+		deadline: newDeadline(),
+	}
+	network.packets[addr] = pc
+	return pc, nil
+}
+
+// ResolveAddr returns the memory address of addr, it does not check that anybody listens at addr
+func (network *MemoryNetwork) ResolveAddr(addr string) (net.Addr, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	return memAddr(addr), nil
+}
+
+// Listen accepts the streams dialed to addr
+func (network *MemoryNetwork) Listen(addr string) (net.Listener, error) {
+	network.mux.Lock()
+	defer network.mux.Unlock()
+	if _, ok := network.listeners[addr]; ok {
+		return nil, &net.OpError{Op: "listen", Net: "memory", Addr: memAddr(addr), Err: errAddrInUse}
+	}
+	ln := &memListener{
+		network: network,
+		addr:    memAddr(addr),
+		accept:  make(chan net.Conn, memQueueSize),
+		closed:  make(chan struct{}),
+	}
+	network.listeners[addr] = ln
+	return ln, nil
+}
+
+// Dial opens a stream to the listener at addr, it is refused when the backlog of the listener is full
+func (network *MemoryNetwork) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	network.mux.Lock()
+	defer network.mux.Unlock()
+	ln, ok := network.listeners[addr]
+	if !ok {
+		ln, ok = network.listeners[wildcard(addr)]
+	}
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memAddr(addr), Err: errConnRefused}
+	}
+	network.nextPort++
+	local := memAddr(net.JoinHostPort("memory", strconv.Itoa(network.nextPort)))
+	client, server := net.Pipe()
+	select {
+	case ln.accept <- &memConn{Conn: server, local: memAddr(addr), remote: local}:
+		return &memConn{Conn: client, local: local, remote: memAddr(addr)}, nil
+	default:
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memAddr(addr), Err: errConnRefused}
+	}
+}
+
+// send hands a copy of a datagram over to the Conditioner before it is delivered
+func (network *MemoryNetwork) send(from memAddr, b []byte, to net.Addr) {
+	d := datagram{data: append([]byte(nil), b...), from: from}
+	if network.Conditioner == nil {
+		network.deliver(d, to)
+		return
+	}
+	delay, drop := network.Conditioner.Datagram(string(from), to.String(), len(b))
+	switch {
+	case drop:
+	case delay <= 0:
+		network.deliver(d, to)
+	default:
+		time.AfterFunc(delay, func() { network.deliver(d, to) })
+	}
+}
+
+// deliver queues a datagram at its destination
+func (network *MemoryNetwork) deliver(d datagram, to net.Addr) {
+	network.mux.Lock()
+	pc, ok := network.packets[to.String()]
+	if !ok {
+		pc, ok = network.packets[wildcard(to.String())]
+	}
+	network.mux.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case pc.queue <- d:
+	case <-pc.closed:
+	default:
+	}
+}
+
+type datagram struct {
+	data []byte
+	from memAddr
+}
+
+// memPacketConn is a datagram endpoint of a MemoryNetwork
+type memPacketConn struct {
+	network   *MemoryNetwork
+	addr      memAddr
+	queue     chan datagram
+	closed    chan struct{}
+	closeOnce sync.Once
+	deadline  *deadline // read deadline, writes never block
+}
+
+func (pc *memPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		d, retry, err := pc.receive()
+		if retry {
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		return copy(b, d.data), d.from, nil
+	}
+}
+
+// receive waits for a datagram until the read deadline, retry is set if the deadline is moved meanwhile
+func (pc *memPacketConn) receive() (d datagram, retry bool, err error) {
+	t, changed := pc.deadline.get()
+	var expired <-chan time.Time
+	if !t.IsZero() {
+		timer := time.NewTimer(time.Until(t))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case d = <-pc.queue:
+		return d, false, nil
+	case <-pc.closed:
+		return d, false, net.ErrClosed
+	case <-expired:
+		return d, false, os.ErrDeadlineExceeded
+	case <-changed:
+		return d, true, nil
+	}
+}
+
+func (pc *memPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-pc.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if _, ok := addr.(memAddr); !ok {
+		return 0, &net.OpError{Op: "write", Net: "memory", Addr: addr, Err: errInvalidAddrType}
+	}
+	pc.network.send(pc.addr, b, addr)
+	return len(b), nil
+}
+
+func (pc *memPacketConn) Close() error {
+	pc.closeOnce.Do(func() {
+		pc.network.mux.Lock()
+		delete(pc.network.packets, string(pc.addr))
+		pc.network.mux.Unlock()
+		close(pc.closed)
+	})
+	return nil
+}
+
+func (pc *memPacketConn) LocalAddr() net.Addr                { return pc.addr }
+func (pc *memPacketConn) SetDeadline(t time.Time) error      { return pc.SetReadDeadline(t) }
+func (pc *memPacketConn) SetReadDeadline(t time.Time) error  { pc.deadline.set(t); return nil }
+func (pc *memPacketConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// memListener accepts the streams of a MemoryNetwork
+type memListener struct {
+	network   *MemoryNetwork
+	addr      memAddr
+	accept    chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (ln *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.accept:
+		return conn, nil
+	case <-ln.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close resets the streams which were not accepted yet, like a TCP listener, so that their writers fail
+// instead of blocking on the synchronous pipes
+func (ln *memListener) Close() error {
+	ln.closeOnce.Do(func() {
+		ln.network.mux.Lock()
+		defer ln.network.mux.Unlock()
+		delete(ln.network.listeners, string(ln.addr))
+		close(ln.closed)
+		for {
+			select {
+			case conn := <-ln.accept:
+				conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (ln *memListener) Addr() net.Addr { return ln.addr }
+
+// memConn is a stream of a MemoryNetwork, it reports the memory addresses of its ends
+type memConn struct {
+	net.Conn
+	local, remote memAddr
+}
+
+func (c *memConn) LocalAddr() net.Addr  { return c.local }
+func (c *memConn) RemoteAddr() net.Addr { return c.remote }
+
+// deadline signals the expiry of a deadline which can be moved while a reader waits for it
+type deadline struct {
+	mux     sync.Mutex
+	t       time.Time
+	changed chan struct{} // closed when t is set
+}
+
+func newDeadline() *deadline {
+	return &deadline{changed: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.t = t
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// get returns the current deadline, zero if there is none, and a channel closed when it is set again
+func (d *deadline) get() (time.Time, <-chan struct{}) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.t, d.changed
+}
@@ -0,0 +1,51 @@
+// Package transport abstracts the network used by coopcast and manycast nodes.
+//
+// A node sends best effort datagrams, which carry the encoded symbols, and opens reliable streams,
+// which carry the decode acknowledgements and the manycast payloads. Net implements them with UDP
+// and TCP sockets, MemoryNetwork connects the nodes of a single process without any socket, so that
+// tests and simulations are deterministic. Another protocol, QUIC for instance, only has to provide
+// the same datagram and stream endpoints.
+package transport
+
+import (
+	"context"
+	"net"
+)
+
+// Transport opens the endpoints of a node, addresses are host:port strings
+type Transport interface {
+	// ListenPacket opens the datagram endpoint of the node at addr
+	ListenPacket(addr string) (net.PacketConn, error)
+	// ResolveAddr returns the address to which datagrams for addr are written with WriteTo
+	ResolveAddr(addr string) (net.Addr, error)
+	// Listen accepts the streams opened to addr
+	Listen(addr string) (net.Listener, error)
+	// Dial opens a stream to addr
+	Dial(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// Net is the Transport of the operating system network, datagrams over UDP and streams over TCP
+type Net struct{}
+
+var _ Transport = Net{}
+
+// ListenPacket opens a UDP socket bound to addr
+func (Net) ListenPacket(addr string) (net.PacketConn, error) {
+	return net.ListenPacket("udp", addr)
+}
+
+// ResolveAddr resolves a UDP address
+func (Net) ResolveAddr(addr string) (net.Addr, error) {
+	return net.ResolveUDPAddr("udp", addr)
+}
+
+// Listen opens a TCP listener bound to addr
+func (Net) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+// Dial opens a TCP connection to addr
+func (Net) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}